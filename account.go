@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// How long a password reset link works for
+const resetLifetime = time.Hour
+
+var resetPath = regexp.MustCompile("^/reset/([0-9a-f]{64})$")
+
+// Check an email address as typed on a form, allowing it to be left blank
+func checkEmail(email string) error {
+	if email == "" {
+		return nil
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return errors.New("that doesn't look like an email address")
+	}
+	return nil
+}
+
+type accountForm struct {
+	User     *User
+	Mail     bool
+	Saved    bool
+	Error    string
+	SiteZone string // shown while they haven't picked their own
+}
+
+// /account lets a user set the email address notifications and password resets
+// go to, the language the wiki speaks to them in and the zone times are shown in
+func accountHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	form := accountForm{User: user, Mail: mailEnabled(), Saved: r.FormValue("saved") != "", SiteZone: zoneName(siteZone)}
+	if r.Method == http.MethodPost {
+		email := r.FormValue("email")
+		notify := r.FormValue("notify") != ""
+		language := r.FormValue("language")
+		if _, ok := locales[language]; language != "" && !ok {
+			form.Error = "There's no translation for that language."
+			w.WriteHeader(http.StatusBadRequest)
+			renderTemplate(w, r, "account", form)
+			return
+		}
+		zone := strings.TrimSpace(r.FormValue("time_zone"))
+		if _, err := loadZone(zone); zone != "" && err != nil {
+			form.Error = "That isn't a time zone. Try one such as Europe/Berlin or America/New_York."
+			w.WriteHeader(http.StatusBadRequest)
+			renderTemplate(w, r, "account", form)
+			return
+		}
+		if err := checkEmail(email); err != nil {
+			form.Error = err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			renderTemplate(w, r, "account", form)
+			return
+		}
+		if err := users.update(user.Username, func(u *User) {
+			u.Email = email
+			u.NotifyByEmail = notify
+			u.Language = language
+			u.TimeZone = zone
+		}); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, sitePath("/account?saved=1"), http.StatusFound)
+		return
+	}
+	renderTemplate(w, r, "account", form)
+}
+
+type resetToken struct {
+	Username string
+	Expires  time.Time
+}
+
+// Reset tokens are kept in memory like sessions, so a restart cancels any outstanding links
+var resets = struct {
+	sync.Mutex
+	tokens map[string]resetToken
+}{tokens: make(map[string]resetToken)}
+
+func newResetToken(username string) (string, error) {
+	token, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+	resets.Lock()
+	defer resets.Unlock()
+	resets.tokens[token] = resetToken{Username: username, Expires: time.Now().Add(resetLifetime)}
+	return token, nil
+}
+
+// Look up a reset token; using it removes it, so each link works once
+func checkResetToken(token string, use bool) (string, bool) {
+	resets.Lock()
+	defer resets.Unlock()
+	t, ok := resets.tokens[token]
+	if !ok || time.Now().After(t.Expires) {
+		delete(resets.tokens, token)
+		return "", false
+	}
+	if use {
+		delete(resets.tokens, token)
+	}
+	return t.Username, true
+}
+
+// Password resets only make sense for local accounts, and need mail to send the link
+func resetsEnabled() bool {
+	return accounts.canRegister() && mailEnabled()
+}
+
+type resetForm struct {
+	Token string
+	Sent  bool
+	Error string
+}
+
+// /reset asks for a username and mails its owner a link to /reset/<token>, where they pick a new password.
+// Whether an account exists or has an address is never let on, so the form can't be used to find out.
+func resetHandler(w http.ResponseWriter, r *http.Request) {
+	if !resetsEnabled() {
+		notFound(w, r)
+		return
+	}
+	if r.URL.Path == "/reset" {
+		form := resetForm{}
+		if r.Method == http.MethodPost {
+			if u := users.get(r.FormValue("username")); u != nil && u.Email != "" && u.PasswordHash != "" {
+				token, err := newResetToken(u.Username)
+				if err != nil {
+					serverError(w, r, err)
+					return
+				}
+				sendMail(u.Email, userLocale(u), "password-reset", map[string]any{"User": u.Username, "ResetURL": sitePath("/reset/" + token)})
+			}
+			form.Sent = true
+		}
+		renderTemplate(w, r, "reset", form)
+		return
+	}
+
+	m := resetPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		notFound(w, r)
+		return
+	}
+	form := resetForm{Token: m[1]}
+	if _, ok := checkResetToken(form.Token, false); !ok {
+		httpError(w, r, http.StatusNotFound, "That reset link has expired or already been used. You can ask for another one.")
+		return
+	}
+	if r.Method == http.MethodPost {
+		password := r.FormValue("password")
+		switch {
+		case len(password) < 8:
+			form.Error = "passwords must be at least 8 characters"
+		case password != r.FormValue("confirm"):
+			form.Error = "passwords do not match"
+		}
+		if form.Error != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			renderTemplate(w, r, "reset", form)
+			return
+		}
+		name, ok := checkResetToken(form.Token, true)
+		if !ok {
+			httpError(w, r, http.StatusNotFound, "That reset link has expired or already been used. You can ask for another one.")
+			return
+		}
+		if err := users.setPassword(name, password); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		log.Printf("Password of %s was reset\n", name)
+		http.Redirect(w, r, sitePath("/login"), http.StatusFound)
+		return
+	}
+	renderTemplate(w, r, "reset", form)
+}
+
+func (s *userStore) setPassword(username, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return s.update(username, func(u *User) { u.PasswordHash = string(hash) })
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Permission levels on a page; each level implies the ones below it
+type Permission int
+
+const (
+	permNone Permission = iota
+	permRead
+	permWrite
+	permAdmin
+)
+
+// Grants everyone (anonymous visitors for read, any logged in user otherwise)
+const everyone = "*"
+
+var adminPermissionsPath = regexp.MustCompile("^/admin/permissions/(.+)$")
+
+// ACL lists who holds each permission on a page. An empty list falls back to
+// the default: anyone may read, anyone logged in who can read may write, and
+// only site admins administer. System pages can only be changed by site admins,
+// whatever the lists say.
+type ACL struct {
+	Read   []string `json:"read,omitempty"`
+	Write  []string `json:"write,omitempty"`
+	Admin  []string `json:"admin,omitempty"`
+	System bool     `json:"system,omitempty"`
+}
+
+func aclDir() string {
+	return dataPath(".acl")
+}
+
+func aclFile(title string) string {
+	return filepath.Join(aclDir(), titleFileName(title)+".json")
+}
+
+func loadACL(title string) (*ACL, error) {
+	acl := &ACL{}
+	data, err := os.ReadFile(aclFile(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return acl, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return acl, json.Unmarshal(data, acl)
+}
+
+func saveACL(title string, acl *ACL) error {
+	if err := os.MkdirAll(aclDir(), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(acl, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(aclFile(title), data, 0600); err != nil {
+		return err
+	}
+	// pages including this one may no longer be allowed to
+	invalidateRenders(title)
+	return nil
+}
+
+// Does the list grant the user? An anonymous user only matches an everyone entry.
+func grants(list []string, user *User) bool {
+	if slices.Contains(list, everyone) {
+		return true
+	}
+	return user != nil && slices.Contains(list, user.Username)
+}
+
+// Work out the highest permission a user holds on a page
+func (acl *ACL) permission(user *User) Permission {
+	if user != nil && user.Admin {
+		return permAdmin
+	}
+	// write and admin need an account, even when granted to everyone, though
+	// anonymous edits let visitors write wherever any account could
+	canRead := len(acl.Read) == 0 || grants(acl.Read, user)
+	switch {
+	case user != nil && grants(acl.Admin, user):
+		return permAdmin
+	case (user != nil || config.AnonymousEdits) && (grants(acl.Write, user) || (len(acl.Write) == 0 && canRead)):
+		return permWrite
+	case canRead:
+		return permRead
+	}
+	return permNone
+}
+
+// Is the page a system page, either marked as one or listed in the config?
+func (acl *ACL) system(title string) bool {
+	if acl.System {
+		return true
+	}
+	for _, page := range config.SystemPages {
+		if page == title || strings.HasSuffix(page, namespaceSeparator) && strings.HasPrefix(title, page) {
+			return true
+		}
+	}
+	return false
+}
+
+func systemPage(title string) bool {
+	acl, err := loadACL(title)
+	return err == nil && acl.system(title)
+}
+
+func pagePermission(r *http.Request, title string) (Permission, error) {
+	return userPermission(currentUser(r), title)
+}
+
+// What a user may do with a page, for when there's no request of theirs to
+// go by, as when they're sent a notification
+func userPermission(user *User, title string) (Permission, error) {
+	acl, err := loadACL(title)
+	if err != nil {
+		return permNone, err
+	}
+	perm := acl.permission(user)
+	if perm > permRead && acl.system(title) && (user == nil || !user.Admin) {
+		perm = permRead
+	}
+	return perm, nil
+}
+
+// Respond and return false unless the user holds the permission on the page.
+// Anonymous users are sent to log in first, since an account may be all they need.
+func checkPermission(w http.ResponseWriter, r *http.Request, title string, want Permission) bool {
+	have, err := pagePermission(r, title)
+	if err != nil {
+		serverError(w, r, err)
+		return false
+	}
+	if have >= want {
+		return true
+	}
+	if currentUser(r) == nil {
+		next := r.URL.EscapedPath()
+		if r.Method != http.MethodGet {
+			next = "/"
+		}
+		http.Redirect(w, r, sitePath("/login?next="+url.QueryEscape(next)), http.StatusFound)
+		return false
+	}
+	if want > permRead && systemPage(title) {
+		httpError(w, r, http.StatusForbidden, title+" is a system page, so only admins can change it.")
+		return false
+	}
+	httpError(w, r, http.StatusForbidden, "You don't have permission to do that.")
+	return false
+}
+
+// Gate a page handler behind a permission on the page
+func requirePermission(want Permission, fn func(http.ResponseWriter, *http.Request, string)) func(http.ResponseWriter, *http.Request, string) {
+	return func(w http.ResponseWriter, r *http.Request, title string) {
+		if checkPermission(w, r, title, want) {
+			fn(w, r, title)
+		}
+	}
+}
+
+// Keep the titles the user is allowed to read
+func readableTitles(r *http.Request, titles []string) []string {
+	var readable []string
+	for _, title := range titles {
+		if perm, err := pagePermission(r, title); err == nil && perm >= permRead {
+			readable = append(readable, title)
+		}
+	}
+	return readable
+}
+
+// Parse a comma or whitespace separated list of usernames from the permissions form
+func parsePrincipals(s string) []string {
+	var list []string
+	for _, name := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t' }) {
+		if !slices.Contains(list, name) {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+func permissionsHandler(w http.ResponseWriter, r *http.Request) {
+	m := adminPermissionsPath.FindStringSubmatch(r.URL.Path)
+	if m == nil || !validTitle(m[1]) {
+		notFound(w, r)
+		return
+	}
+	title := m[1]
+	if !checkPermission(w, r, title, permAdmin) {
+		return
+	}
+
+	acl, err := loadACL(title)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	siteAdmin := currentUser(r).Admin
+
+	if r.Method == http.MethodPost {
+		acl := &ACL{
+			Read:  parsePrincipals(r.FormValue("read")),
+			Write: parsePrincipals(r.FormValue("write")),
+			Admin: parsePrincipals(r.FormValue("admin")),
+			// only site admins can make a page a system page, or stop it being one
+			System: acl.System,
+		}
+		if siteAdmin {
+			acl.System = r.FormValue("system") != ""
+		}
+		if err := saveACL(title, acl); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		audit(r, "permissions", title, "read: "+strings.Join(acl.Read, ", ")+"; write: "+strings.Join(acl.Write, ", ")+"; admin: "+strings.Join(acl.Admin, ", ")+"; system: "+strconv.FormatBool(acl.System))
+		http.Redirect(w, r, pageURL("admin/permissions", title), http.StatusFound)
+		return
+	}
+
+	renderTemplate(w, r, "permissions", struct {
+		Title     string
+		ACL       *ACL
+		SiteAdmin bool
+		ByConfig  bool
+	}{title, acl, siteAdmin, !acl.System && acl.system(title)})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// What /admin shows: the settings admins can change while the wiki runs,
+// everyone with an account, and how the background jobs are getting on
+type adminPanel struct {
+	SiteName   string
+	HomePage   string
+	ReadOnly   bool
+	Users      []User
+	Me         string
+	Blocks     []Block
+	Index      indexStatus
+	LastBackup time.Time
+	BackupErr  string
+	Saved      bool
+	Error      string
+}
+
+func newAdminPanel(r *http.Request) adminPanel {
+	p := adminPanel{
+		SiteName: siteName(),
+		HomePage: homePage(),
+		ReadOnly: readOnly.Load(),
+		Users:    users.list(),
+		Me:       username(r),
+		Blocks:   blocks.active(),
+		Index:    indexer.progress(),
+		Saved:    r.FormValue("saved") != "",
+	}
+	backupMu.Lock()
+	p.LastBackup = lastBackup
+	if lastBackupErr != nil {
+		p.BackupErr = lastBackupErr.Error()
+	}
+	backupMu.Unlock()
+	return p
+}
+
+// Forms on /admin post to the admin page they belong to, such as
+// /admin/blocks, with from=admin to come back to /admin afterwards
+func backToAdmin(w http.ResponseWriter, r *http.Request, fallback string) {
+	target := fallback
+	if r.FormValue("from") == "admin" {
+		target = "/admin"
+	}
+	http.Redirect(w, r, sitePath(target), http.StatusFound)
+}
+
+// /admin changes the site's name and home page, and makes users admins or
+// deletes them. Read-only mode, blocks, reindexing and backups are done by
+// their own admin pages, which its forms post to.
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderTemplate(w, r, "admin", newAdminPanel(r))
+		return
+	}
+	var message string
+	var err error
+	switch r.FormValue("action") {
+	case "settings":
+		message, err = saveSiteSettings(r)
+	case "grant", "revoke", "delete":
+		message, err = changeUser(r, r.FormValue("action"), r.FormValue("username"))
+	default:
+		message = "That isn't something the admin page can do."
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if message != "" {
+		p := newAdminPanel(r)
+		p.Error = message
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "admin", p)
+		return
+	}
+	http.Redirect(w, r, sitePath("/admin?saved=1"), http.StatusFound)
+}
+
+// Save the site's name and home page, or say what's wrong with them
+func saveSiteSettings(r *http.Request) (string, error) {
+	name := strings.Join(strings.Fields(r.FormValue("site_name")), " ")
+	home := strings.TrimSpace(r.FormValue("home_page"))
+	switch {
+	case name == "":
+		return "Give the wiki a name.", nil
+	case !validTitle(home):
+		return "The home page has to be a page title, such as HomePage.", nil
+	}
+	if err := saveSettings(func(s *siteSettings) { s.SiteName, s.HomePage = name, home }); err != nil {
+		return "", err
+	}
+	audit(r, "settings", "", "site name "+name+", home page "+home)
+	return "", nil
+}
+
+// Make someone an admin, take that away, or delete their account. Admins
+// can't do the last two to themselves, so the wiki always has one left.
+func changeUser(r *http.Request, action, name string) (string, error) {
+	if users.get(name) == nil {
+		return "There's no account called that.", nil
+	}
+	if name == username(r) && action != "grant" {
+		return "You can't do that to your own account: ask another admin.", nil
+	}
+	var err error
+	switch action {
+	case "grant", "revoke":
+		err = users.update(name, func(u *User) { u.Admin = action == "grant" })
+	case "delete":
+		if err = users.remove(name); err == nil {
+			err = tokens.revokeAll(name)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	audit(r, "user", "", action+" "+name)
+	return "", nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const apiPrefix = "/api/v1/pages"
+
+var apiPagePath = regexp.MustCompile("^" + apiPrefix + "/(.+)$")
+
+type apiPage struct {
+	Title   string    `json:"title"`
+	Body    string    `json:"body"`
+	Summary string    `json:"summary,omitempty"`
+	Meta    *PageMeta `json:"meta,omitempty"`
+	// worked out from the body, so ignored when saving
+	Words          int `json:"words,omitempty"`
+	ReadingMinutes int `json:"reading_minutes,omitempty"`
+}
+
+type apiPageRef struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+type apiErrorBody struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		// the status line is already out, so all we can do is note it
+		log.Printf("Couldn't encode API response: %s\n", err.Error())
+	}
+}
+
+func apiError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiErrorBody{Error: msg})
+}
+
+// Pick the response type from the Accept header: JSON unless the client only wants plain text.
+// Returns "" when nothing the API can produce is acceptable.
+func negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offers[0]
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		for _, offer := range offers {
+			if mediaType == offer || mediaType == "*/*" || mediaType == strings.Split(offer, "/")[0]+"/*" {
+				return offer
+			}
+		}
+	}
+	return ""
+}
+
+// Like checkPermission, but answers in JSON: 401 when logging in might help, 403 when it won't
+func apiCheckPermission(w http.ResponseWriter, r *http.Request, title string, want Permission) bool {
+	// anonymous edits go through the edit form and its spam checks, never the API
+	if want > permRead && currentUser(r) == nil {
+		apiError(w, http.StatusUnauthorized, "authentication required")
+		return false
+	}
+	have, err := pagePermission(r, title)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if have >= want {
+		return true
+	}
+	if currentUser(r) == nil {
+		apiError(w, http.StatusUnauthorized, "authentication required")
+		return false
+	}
+	apiError(w, http.StatusForbidden, "permission denied")
+	return false
+}
+
+func apiPagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != apiPrefix && r.URL.Path != apiPrefix+"/" {
+		apiPageHandler(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if negotiate(r, "application/json") == "" {
+		apiError(w, http.StatusNotAcceptable, "page listings are only available as application/json")
+		return
+	}
+	titles, err := store.List()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	refs := []apiPageRef{}
+	for _, title := range readableTitles(r, titles) {
+		refs = append(refs, apiPageRef{Title: title, URL: sitePath(apiPrefix + "/" + titlePath(title))})
+	}
+	writeJSON(w, http.StatusOK, refs)
+}
+
+func apiPageHandler(w http.ResponseWriter, r *http.Request) {
+	m := apiPagePath.FindStringSubmatch(r.URL.Path)
+	if m == nil || !validTitle(m[1]) {
+		apiError(w, http.StatusNotFound, "no such page")
+		return
+	}
+	title := m[1]
+
+	if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+		if readOnly.Load() {
+			apiError(w, http.StatusForbidden, "the wiki is read-only")
+			return
+		}
+		if b := blocks.find(r); b != nil {
+			apiError(w, http.StatusForbidden, "you're blocked from editing: "+b.Reason)
+			return
+		}
+		if !allowWrite(w, r) {
+			apiError(w, http.StatusTooManyRequests, "too many changes, slow down")
+			return
+		}
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		apiGetPage(w, r, title)
+	case http.MethodPut:
+		apiPutPage(w, r, title)
+	case http.MethodDelete:
+		apiDeletePage(w, r, title)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func apiGetPage(w http.ResponseWriter, r *http.Request, title string) {
+	if !apiCheckPermission(w, r, title, permRead) {
+		return
+	}
+	contentType := negotiate(r, "application/json", "text/plain")
+	if contentType == "" {
+		apiError(w, http.StatusNotAcceptable, "pages are available as application/json or text/plain")
+		return
+	}
+	p, err := loadPage(r.Context(), title)
+	if timedOut(err) {
+		apiError(w, http.StatusServiceUnavailable, "timed out loading the page")
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusNotFound, "no such page")
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	if contentType == "text/plain" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(p.Body)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiPage{Title: p.Title, Body: string(p.Body), Meta: &p.Meta, Words: p.WordCount(), ReadingMinutes: p.ReadingMinutes()})
+}
+
+// PUT takes either a JSON page object or the raw body as text/plain, with the
+// edit summary given as a summary query parameter
+func apiPutPage(w http.ResponseWriter, r *http.Request, title string) {
+	if !apiCheckPermission(w, r, title, permWrite) {
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		apiError(w, http.StatusUnsupportedMediaType, "a Content-Type of application/json or text/plain is required")
+		return
+	}
+
+	var body []byte
+	// a body without a Content-Length only finds out it's too big part way through
+	var tooLarge *http.MaxBytesError
+	summary := r.URL.Query().Get("summary")
+	switch mediaType {
+	case "application/json":
+		var in apiPage
+		if err := json.NewDecoder(r.Body).Decode(&in); errors.As(err, &tooLarge) {
+			requestTooLarge(w, r)
+			return
+		} else if err != nil {
+			apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		if in.Title != "" && in.Title != title {
+			apiError(w, http.StatusBadRequest, "title in body doesn't match the URL")
+			return
+		}
+		body = []byte(in.Body)
+		if in.Summary != "" {
+			summary = in.Summary
+		}
+	case "text/plain":
+		if body, err = io.ReadAll(r.Body); errors.As(err, &tooLarge) {
+			requestTooLarge(w, r)
+			return
+		} else if err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		apiError(w, http.StatusUnsupportedMediaType, "a Content-Type of application/json or text/plain is required")
+		return
+	}
+
+	if _, _, err := parseFrontMatter(body); err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	status := http.StatusOK
+	if !pageExists(title) {
+		status = http.StatusCreated
+		w.Header().Set("Location", sitePath(apiPrefix+"/"+titlePath(title)))
+	}
+	p := &Page{Title: title, Body: body}
+	edit := newEdit(r, summary)
+	if err := p.save(r.Context(), edit); errors.Is(err, errPageTooLarge) {
+		apiError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("pages are limited to %d bytes", config.MaxPageBytes))
+		return
+	} else if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	audit(r, "save", title, edit.Summary)
+	writeJSON(w, status, apiPage{Title: p.Title, Body: string(p.Body), Meta: &p.Meta, Words: p.WordCount(), ReadingMinutes: p.ReadingMinutes()})
+}
+
+func apiDeletePage(w http.ResponseWriter, r *http.Request, title string) {
+	if !apiCheckPermission(w, r, title, permWrite) {
+		return
+	}
+	err := deletePage(r.Context(), title, newEdit(r, "Deleted "+title))
+	if errors.Is(err, os.ErrNotExist) {
+		apiError(w, http.StatusNotFound, "no such page")
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	audit(r, "delete", title, "")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	attachmentPath = regexp.MustCompile(`^/attachments/(.+)/([a-zA-Z0-9_-][a-zA-Z0-9._-]*)$`)
+	attachmentName = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9._-]*$`)
+)
+
+// The kinds of file pages may carry, keyed by extension. Uploads must also sniff as the same type.
+var attachmentTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".pdf":  "application/pdf",
+	".txt":  "text/plain",
+}
+
+// attachmentStore keeps the files attached to pages: in the data directory,
+// or beside the pages when they're in S3
+type attachmentStore interface {
+	list(title string) ([]string, error)
+	save(title, name string, r io.Reader) error
+	open(title, name string) (io.ReadSeekCloser, time.Time, error)
+}
+
+var attachments attachmentStore = fileAttachments{}
+
+type fileAttachments struct{}
+
+func attachmentDir(title string) string {
+	return dataPath("attachments", titleFileName(title))
+}
+
+func (fileAttachments) list(title string) ([]string, error) {
+	entries, err := os.ReadDir(attachmentDir(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (fileAttachments) save(title, name string, r io.Reader) error {
+	if err := os.MkdirAll(attachmentDir(title), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(attachmentDir(title), name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (fileAttachments) open(title, name string) (io.ReadSeekCloser, time.Time, error) {
+	f, err := os.Open(filepath.Join(attachmentDir(title), name))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	return f, info.ModTime(), nil
+}
+
+// List the names of a page's attachments
+func listAttachments(title string) ([]string, error) {
+	return attachments.list(title)
+}
+
+// Open one of a page's attachments, if it has one by that name
+func openAttachment(title, name string) (io.ReadSeekCloser, time.Time, error) {
+	names, err := listAttachments(title)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if !slices.Contains(names, name) {
+		return nil, time.Time{}, os.ErrNotExist
+	}
+	return attachments.open(title, name)
+}
+
+func isImage(name string) bool {
+	return strings.HasPrefix(attachmentTypes[strings.ToLower(filepath.Ext(name))], "image/")
+}
+
+// Check an upload's name and contents against the allowed types
+func validateAttachment(name string, head []byte) error {
+	if !attachmentName.MatchString(name) {
+		return errors.New("file names may only contain letters, digits, '.', '_' and '-'")
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	want, ok := attachmentTypes[ext]
+	if !ok {
+		return fmt.Errorf("%s files can't be attached", ext)
+	}
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	// webp isn't recognised by the sniffer, so accept its container type
+	if sniffed != want && !(want == "image/webp" && sniffed == "application/octet-stream") {
+		return fmt.Errorf("the file's contents don't look like %s", want)
+	}
+	return nil
+}
+
+func saveAttachment(title, name string, r io.Reader) error {
+	return attachments.save(title, name, r)
+}
+
+// GET lists a page's attachments with an upload form, POST stores a new one
+func uploadHandler(w http.ResponseWriter, r *http.Request, title string) {
+	var uploadErr string
+	if r.Method == http.MethodPost {
+		name, err := receiveUpload(w, r, title)
+		if err == nil {
+			audit(r, "upload", title, name)
+			http.Redirect(w, r, pageURL("upload", title), http.StatusFound)
+			return
+		}
+		uploadErr = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	names, err := listAttachments(title)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "upload", struct {
+		Title       string
+		Attachments []string
+		Error       string
+		MaxMB       int64
+	}{title, names, uploadErr, config.MaxUploadBytes >> 20})
+}
+
+// Store an uploaded attachment, returning its name
+func receiveUpload(w http.ResponseWriter, r *http.Request, title string) (string, error) {
+	// leave some room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxUploadBytes+1<<20)
+	file, header, err := r.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return "", fmt.Errorf("attachments are limited to %d MB", config.MaxUploadBytes>>20)
+	}
+	if err != nil {
+		return "", errors.New("choose a file to upload")
+	}
+	defer file.Close()
+	if header.Size > config.MaxUploadBytes {
+		return "", fmt.Errorf("attachments are limited to %d MB", config.MaxUploadBytes>>20)
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	name := filepath.Base(header.Filename)
+	if err := validateAttachment(name, head[:n]); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return name, saveAttachment(title, name, file)
+}
+
+func attachmentHandler(w http.ResponseWriter, r *http.Request) {
+	m := attachmentPath.FindStringSubmatch(r.URL.Path)
+	if m == nil || !validTitle(m[1]) {
+		notFound(w, r)
+		return
+	}
+	title, name := m[1], m[2]
+	if !checkPermission(w, r, title, permRead) {
+		return
+	}
+	f, modTime, err := openAttachment(title, name)
+	if errors.Is(err, os.ErrNotExist) {
+		notFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if contentType, ok := attachmentTypes[strings.ToLower(filepath.Ext(name))]; ok {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if !isImage(name) {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	}
+	http.ServeContent(w, r, name, modTime, f)
+}
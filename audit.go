@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many entries the audit page shows at once
+const auditLimit = 200
+
+// An AuditEntry records one change to the wiki: who made it, from where,
+// and what it was. Unlike the change log it covers more than page edits.
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user,omitempty"`
+	IP     string    `json:"ip"`
+	Action string    `json:"action"`
+	Title  string    `json:"title,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+var auditLogMu sync.Mutex
+
+func auditLogFile() string {
+	return dataPath(".audit.jsonl")
+}
+
+// Append a change to the audit log. By now the change has been made, so a
+// failure to record it is logged rather than failing the request.
+func audit(r *http.Request, action, title, detail string) {
+	entry := AuditEntry{Time: time.Now().UTC(), User: username(r), IP: clientIP(r), Action: action, Title: title, Detail: detail}
+	if err := appendAudit(entry); err != nil {
+		log.Printf("Couldn't write the audit log: %s %s by %s: %s\n", action, title, entry.User, err.Error())
+	}
+}
+
+func appendAudit(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	auditLogMu.Lock()
+	defer auditLogMu.Unlock()
+	f, err := os.OpenFile(auditLogFile(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// What to show from the audit log. Empty fields match everything; the title
+// matches anywhere in a page's title.
+type auditFilter struct {
+	User   string
+	Action string
+	Title  string
+}
+
+func (f auditFilter) matches(e AuditEntry) bool {
+	return (f.User == "" || e.User == f.User) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Title == "" || strings.Contains(strings.ToLower(e.Title), strings.ToLower(f.Title)))
+}
+
+// Read the audit log, newest first, keeping at most limit entries that match
+func readAudit(filter auditFilter, limit int) ([]AuditEntry, error) {
+	auditLogMu.Lock()
+	defer auditLogMu.Unlock()
+	f, err := os.Open(auditLogFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || !filter.matches(e) {
+			continue
+		}
+		entries = append(entries, e)
+		if len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	filter := auditFilter{User: r.FormValue("user"), Action: r.FormValue("action"), Title: r.FormValue("title")}
+	entries, err := readAudit(filter, auditLimit)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "audit", struct {
+		Filter  auditFilter
+		Entries []AuditEntry
+		Actions []string
+	}{filter, entries, auditActions})
+}
+
+// The actions recorded, for filtering by
+var auditActions = []string{"save", "revert", "delete", "undelete", "purge", "upload", "import", "permissions", "read-only", "comment", "token", "backup", "spam", "block", "reindex"}
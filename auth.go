@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	validUsername = regexp.MustCompile("^[a-zA-Z0-9_.-]{1,32}$")
+
+	errBadCredentials = errors.New("invalid username or password")
+	errUserExists     = errors.New("that username is already taken")
+	errNoRole         = errors.New("your account isn't allowed to use this wiki")
+)
+
+// An authenticator checks the username and password given on the login form
+// and hands back the account they belong to. Local accounts are checked
+// against users.json; other backends vouch for people the wiki then keeps a
+// passwordless record of.
+type authenticator interface {
+	authenticate(username, password string) (*User, error)
+	// whether people can sign themselves up with a password
+	canRegister() bool
+}
+
+// Where logins are checked, picked by the auth setting
+var accounts authenticator = users
+
+func openAuthenticator() error {
+	switch config.Auth {
+	case "local":
+		accounts = users
+	case "ldap":
+		a, err := newLDAPAuth()
+		if err != nil {
+			return err
+		}
+		accounts = a
+	default:
+		return errors.New("unknown auth backend " + config.Auth + ": use local or ldap")
+	}
+	return nil
+}
+
+type User struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Admin        bool   `json:"admin,omitempty"`
+	// accounts made by logging in elsewhere have no password, only who they are there
+	Provider string `json:"provider,omitempty"`
+	Subject  string `json:"subject,omitempty"`
+	// where password resets go, and changes to watched pages if they asked for those
+	Email         string `json:"email,omitempty"`
+	NotifyByEmail bool   `json:"notify_by_email,omitempty"`
+	// the language they picked, over whatever their browser asks for
+	Language string `json:"language,omitempty"`
+	// the zone times are shown in, such as Europe/Berlin, if not the site's
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+// The registered accounts, persisted as JSON alongside the wiki
+type userStore struct {
+	mu    sync.RWMutex
+	path  string
+	users map[string]*User
+}
+
+var users = &userStore{users: make(map[string]*User)}
+
+func (s *userStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []*User
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range list {
+		s.users[u.Username] = u
+	}
+	return nil
+}
+
+// Write the accounts back out; callers must hold the lock
+func (s *userStore) persist() error {
+	list := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, u)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+func (s *userStore) get(username string) *User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.users[username]
+}
+
+// Change an account and save the change
+func (s *userStore) update(username string, fn func(*User)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return errors.New("no such user " + username)
+	}
+	before := *u
+	fn(u)
+	if err := s.persist(); err != nil {
+		*u = before
+		return err
+	}
+	return nil
+}
+
+// Every account, by username
+func (s *userStore) list() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list
+}
+
+// Delete an account. Whoever had it is logged out, as their session no
+// longer finds them.
+func (s *userStore) remove(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return errors.New("no such user " + username)
+	}
+	delete(s.users, username)
+	if err := s.persist(); err != nil {
+		s.users[username] = u
+		return err
+	}
+	return nil
+}
+
+func (s *userStore) add(username, password, email string) (*User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[username]; ok {
+		return nil, errUserExists
+	}
+	// the first account to register administers the wiki
+	u := &User{Username: username, PasswordHash: string(hash), Email: email, Admin: len(s.users) == 0}
+	s.users[username] = u
+	if err := s.persist(); err != nil {
+		delete(s.users, username)
+		return nil, err
+	}
+	return u, nil
+}
+
+// Find or create the account for someone logged in by an identity provider.
+// Their first login claims the username, unless someone already has it.
+func (s *userStore) provision(provider, subject, username, email string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Provider == provider && u.Subject == subject {
+			return u, nil
+		}
+	}
+	if !validUsername.MatchString(username) {
+		return nil, errors.New("your account there doesn't give a usable username")
+	}
+	if _, ok := s.users[username]; ok {
+		return nil, errUserExists
+	}
+	u := &User{Username: username, Provider: provider, Subject: subject, Email: email, Admin: len(s.users) == 0}
+	s.users[username] = u
+	if err := s.persist(); err != nil {
+		delete(s.users, username)
+		return nil, err
+	}
+	return u, nil
+}
+
+// Record someone a directory vouched for under the name they logged in with.
+// A role of admin or user sets whether they administer the wiki; an empty
+// role leaves that as it was, so the first account still gets to.
+func (s *userStore) directoryUser(provider, subject, username, role string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		u = &User{Username: username, Admin: len(s.users) == 0}
+		s.users[username] = u
+	}
+	before := *u
+	u.Provider, u.Subject, u.PasswordHash = provider, subject, ""
+	if role != "" {
+		u.Admin = role == "admin"
+	}
+	if ok && *u == before {
+		return u, nil
+	}
+	if err := s.persist(); err != nil {
+		if ok {
+			*u = before
+		} else {
+			delete(s.users, username)
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *userStore) canRegister() bool {
+	return true
+}
+
+func (s *userStore) authenticate(username, password string) (*User, error) {
+	u := s.get(username)
+	if u == nil || u.PasswordHash == "" {
+		return nil, errBadCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return nil, errBadCredentials
+	}
+	return u, nil
+}
+
+// Only follow local redirects after logging in, so the login form can't be used to bounce users elsewhere
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+type authForm struct {
+	Username    string
+	Next        string
+	Error       string
+	Providers   []OIDCProvider
+	CanRegister bool
+	CanReset    bool
+	Captcha     template.HTML
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	form := authForm{Next: safeNext(r.FormValue("next")), Providers: config.OIDCProviders, CanRegister: accounts.canRegister(), CanReset: resetsEnabled()}
+	if r.Method == http.MethodPost {
+		form.Username = r.FormValue("username")
+		user, err := accounts.authenticate(form.Username, r.FormValue("password"))
+		if err != nil && !errors.Is(err, errBadCredentials) && !errors.Is(err, errNoRole) {
+			serverError(w, r, err)
+			return
+		}
+		if err == nil {
+			if err := startSession(w, r, user.Username); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			http.Redirect(w, r, sitePath(form.Next), http.StatusFound)
+			return
+		}
+		form.Error = err.Error()
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	renderTemplate(w, r, "login", form)
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "That page can only be reached by submitting a form.")
+		return
+	}
+	endSession(w, r)
+	http.Redirect(w, r, sitePath("/"), http.StatusFound)
+}
+
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+	if !accounts.canRegister() {
+		httpError(w, r, http.StatusForbidden, "Accounts here come from the directory, so there's nothing to register: just log in.")
+		return
+	}
+	form := authForm{Next: safeNext(r.FormValue("next")), Captcha: captchaWidget(r, captchaRegister)}
+	if r.Method == http.MethodPost {
+		if refuseBlocked(w, r) {
+			return
+		}
+		form.Username = r.FormValue("username")
+		password := r.FormValue("password")
+		email := r.FormValue("email")
+		var user *User
+		var err error
+		switch {
+		case !validUsername.MatchString(form.Username):
+			err = errors.New("usernames may only contain letters, digits, '.', '_' and '-'")
+		case len(password) < 8:
+			err = errors.New("passwords must be at least 8 characters")
+		case password != r.FormValue("confirm"):
+			err = errors.New("passwords do not match")
+		default:
+			if err = checkCaptchaAnswer(r, captchaRegister); err == nil {
+				err = checkEmail(email)
+			}
+			if err == nil {
+				user, err = users.add(form.Username, password, email)
+			}
+		}
+		if err == nil {
+			sendMail(user.Email, requestLocale(r), "account-created", map[string]any{"User": user.Username})
+			if err := startSession(w, r, user.Username); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			http.Redirect(w, r, sitePath(form.Next), http.StatusFound)
+			return
+		}
+		form.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	renderTemplate(w, r, "register", form)
+}
+
+// Gate a handler behind a login, sending anonymous users to the login form
+func requireAuth(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if currentUser(r) == nil {
+			next := r.URL.EscapedPath()
+			// there's nothing to come back to after a POST, so return to the page instead
+			if r.Method != http.MethodGet {
+				next = "/"
+			}
+			http.Redirect(w, r, sitePath("/login?next="+url.QueryEscape(next)), http.StatusFound)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// Gate the editor behind a login, unless visitors may edit anonymously
+func requireEditor(fn http.HandlerFunc) http.HandlerFunc {
+	login := requireAuth(fn)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.AnonymousEdits {
+			fn(w, r)
+		} else {
+			login(w, r)
+		}
+	}
+}
+
+// Gate a handler behind a site admin account
+func requireAdmin(fn http.HandlerFunc) http.HandlerFunc {
+	return requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if !currentUser(r).Admin {
+			httpError(w, r, http.StatusForbidden, "You don't have permission to do that.")
+			return
+		}
+		fn(w, r)
+	})
+}
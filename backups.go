@@ -0,0 +1,435 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backups are named after when they were taken, to the millisecond, so they
+// sort oldest first. Older backups were named to the second.
+const backupTimeFormat = "20060102-150405.000"
+
+var (
+	backupName     = regexp.MustCompile(`^gowiki-\d{8}-\d{6}(\.\d{3})?\.tar\.gz$`)
+	adminBackupURL = regexp.MustCompile(`^/admin/backups/(.+)$`)
+)
+
+// A backup, as listed on the admin page
+type backupInfo struct {
+	Name string
+	Size int64
+	Time time.Time
+}
+
+// Where backups are kept: a local directory, or an S3 bucket
+type backupTarget interface {
+	save(name string, archive *os.File) error
+	list() ([]backupInfo, error)
+	open(name string) (io.ReadCloser, error)
+	remove(name string) error
+}
+
+type dirBackups string
+
+func (d dirBackups) save(name string, archive *os.File) error {
+	// the archive was written next to where it's going, so this is a rename
+	return os.Rename(archive.Name(), filepath.Join(string(d), name))
+}
+
+func (d dirBackups) list() ([]backupInfo, error) {
+	entries, err := os.ReadDir(string(d))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups []backupInfo
+	for _, entry := range entries {
+		if !backupName.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backupInfo{Name: entry.Name(), Size: info.Size(), Time: info.ModTime()})
+	}
+	return backups, nil
+}
+
+func (d dirBackups) open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), name))
+}
+
+func (d dirBackups) remove(name string) error {
+	return os.Remove(filepath.Join(string(d), name))
+}
+
+// Backups in S3 are kept under backups/ in the bucket
+type s3Backups struct {
+	client *s3Client
+}
+
+const s3BackupPrefix = "backups/"
+
+func (s s3Backups) save(name string, archive *os.File) error {
+	defer os.Remove(archive.Name())
+	info, err := archive.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return s.client.put(context.Background(), s3BackupPrefix+name, archive, info.Size())
+}
+
+func (s s3Backups) list() ([]backupInfo, error) {
+	objects, err := s.client.list(context.Background(), s3BackupPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var backups []backupInfo
+	for _, o := range objects {
+		if name := strings.TrimPrefix(o.Key, s3BackupPrefix); backupName.MatchString(name) {
+			backups = append(backups, backupInfo{Name: name, Size: o.Size, Time: o.LastModified})
+		}
+	}
+	return backups, nil
+}
+
+func (s s3Backups) open(name string) (io.ReadCloser, error) {
+	body, err := s.client.get(context.Background(), s3BackupPrefix+name)
+	if errors.Is(err, errS3NotFound) {
+		return nil, os.ErrNotExist
+	}
+	return body, err
+}
+
+func (s s3Backups) remove(name string) error {
+	return s.client.remove(context.Background(), s3BackupPrefix+name)
+}
+
+var (
+	backups backupTarget = dirBackups("backups")
+	// one backup at a time, and what happened to the last one
+	backupMu      sync.Mutex
+	lastBackup    time.Time
+	lastBackupErr error
+)
+
+func openBackups() error {
+	switch config.BackupTo {
+	case "dir", "":
+		backups = dirBackups(config.BackupDir)
+	case "s3":
+		client, err := newS3Client()
+		if err != nil {
+			return err
+		}
+		backups = s3Backups{client}
+	default:
+		return errors.New("-backup-to must be dir or s3")
+	}
+	return nil
+}
+
+// Take a backup now: a gzipped tar of the data directory and the users
+// file, and of the pages too if they're kept elsewhere, then drop the oldest
+// backups beyond the number kept
+func runBackup() (string, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	name, err := takeBackup()
+	lastBackup, lastBackupErr = time.Now(), err
+	if err != nil {
+		return "", err
+	}
+	return name, pruneBackups()
+}
+
+func takeBackup() (string, error) {
+	name := "gowiki-" + time.Now().UTC().Format(backupTimeFormat) + ".tar.gz"
+	// backupMu keeps another backup from taking the name after this looks
+	existing, err := backups.list()
+	if err != nil {
+		return "", err
+	}
+	if slices.ContainsFunc(existing, func(b backupInfo) bool { return b.Name == name }) {
+		return "", errors.New("there's already a backup called " + name)
+	}
+	dir := os.TempDir()
+	if d, ok := backups.(dirBackups); ok {
+		if err := os.MkdirAll(string(d), os.ModePerm); err != nil {
+			return "", err
+		}
+		dir = string(d)
+	}
+	f, err := os.CreateTemp(dir, ".backup-*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := writeBackup(f); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := backups.save(name, f); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return name, nil
+}
+
+func writeBackup(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	// a backup directory inside the data directory isn't backed up into itself
+	skip, _ := filepath.Abs(config.BackupDir)
+	err := filepath.WalkDir(config.DataDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if abs, _ := filepath.Abs(file); entry.IsDir() && abs == skip {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(config.DataDir, file)
+		if err != nil {
+			return err
+		}
+		return addToBackup(tw, file, filepath.ToSlash(filepath.Join("data", rel)), entry)
+	})
+	if err != nil {
+		return err
+	}
+	if info, err := os.Lstat(config.UsersFile); err == nil {
+		if err := addToBackup(tw, config.UsersFile, "users.json", fs.FileInfoToDirEntry(info)); err != nil {
+			return err
+		}
+	}
+	if !pagesInDataDir() {
+		if err := addStoreToBackup(tw); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addToBackup(tw *tar.Writer, file, name string, entry fs.DirEntry) error {
+	info, err := entry.Info()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() && !info.IsDir() {
+		return nil
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := tw.WriteHeader(header); err != nil || info.IsDir() {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// The file and git stores keep pages in the data directory, so backing it up
+// covers them; the others keep them where only the store can read them
+func pagesInDataDir() bool {
+	switch store.(type) {
+	case fileStore, *gitStore:
+		return true
+	}
+	return false
+}
+
+func addBytesToBackup(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: modTime}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// Read every page, its revisions and attachments out of the store into the
+// backup under store/, laid out as the S3 store keeps them: pages/<title>.txt,
+// history/<title>/<n>.txt with its details in <n>.json, and
+// attachments/<title>/<name>
+func addStoreToBackup(tw *tar.Writer) error {
+	ctx := context.Background()
+	return walkPages("", func(title string) error {
+		p, err := store.Load(ctx, title)
+		if err != nil {
+			return err
+		}
+		if err := addBytesToBackup(tw, "store/pages/"+titlePath(title)+".txt", p.Body, pageModTime(title)); err != nil {
+			return err
+		}
+		revs, err := store.Revisions(title)
+		if err != nil {
+			return err
+		}
+		history := "store/history/" + titleFileName(title) + "/"
+		for _, rev := range revs {
+			body, err := store.LoadRevision(title, rev.Number)
+			if err != nil {
+				return err
+			}
+			meta, err := json.Marshal(rev)
+			if err != nil {
+				return err
+			}
+			n := strconv.Itoa(rev.Number)
+			if err := addBytesToBackup(tw, history+n+".txt", body, rev.Time); err != nil {
+				return err
+			}
+			if err := addBytesToBackup(tw, history+n+".json", meta, rev.Time); err != nil {
+				return err
+			}
+		}
+		// attachments kept in the data directory are in the backup already
+		if _, ok := attachments.(fileAttachments); ok {
+			return nil
+		}
+		names, err := attachments.list(title)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			f, modTime, err := attachments.open(title, name)
+			if err != nil {
+				return err
+			}
+			data, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			if err := addBytesToBackup(tw, "store/attachments/"+titleFileName(title)+"/"+name, data, modTime); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Keep only the newest backups, as many as -backup-keep says (0 keeps them all)
+func pruneBackups() error {
+	if config.BackupKeep <= 0 {
+		return nil
+	}
+	list, err := backups.list()
+	if err != nil {
+		return err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name > list[j].Name })
+	for _, b := range list[min(len(list), config.BackupKeep):] {
+		if err := backups.remove(b.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Take a backup every interval, for as long as the wiki runs
+func backupEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if name, err := runBackup(); err != nil {
+			log.Printf("Backup failed: %s\n", err.Error())
+		} else {
+			log.Printf("Backed up to %s\n", name)
+		}
+	}
+}
+
+// /admin/backups lists the backups, newest first, and takes one on a POST.
+// /admin/backups/<name> downloads one.
+func backupsHandler(w http.ResponseWriter, r *http.Request) {
+	if m := adminBackupURL.FindStringSubmatch(r.URL.Path); m != nil {
+		downloadBackup(w, r, m[1])
+		return
+	}
+	if r.Method == http.MethodPost {
+		name, err := runBackup()
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		audit(r, "backup", "", name)
+		http.Redirect(w, r, sitePath("/admin/backups"), http.StatusFound)
+		return
+	}
+	list, err := backups.list()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name > list[j].Name })
+	backupMu.Lock()
+	data := struct {
+		Backups  []backupInfo
+		Interval time.Duration
+		Keep     int
+		Last     time.Time
+		LastErr  string
+	}{Backups: list, Interval: config.BackupInterval, Keep: config.BackupKeep, Last: lastBackup}
+	if lastBackupErr != nil {
+		data.LastErr = lastBackupErr.Error()
+	}
+	backupMu.Unlock()
+	renderTemplate(w, r, "backups", data)
+}
+
+func downloadBackup(w http.ResponseWriter, r *http.Request, name string) {
+	if !backupName.MatchString(name) {
+		notFound(w, r)
+		return
+	}
+	f, err := backups.open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		notFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	if _, err := io.Copy(w, f); err != nil {
+		log.Printf("Backup download of %s failed: %s\n", name, err.Error())
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// The wiki can be served under a path such as /wiki rather than at the root,
+// so it can share a reverse proxy with other sites. Handlers see paths without
+// it, and it's added back to every link, redirect and cookie handed out.
+
+// Tidy -base-path into "" or a path like /wiki, without a trailing slash
+func checkBasePath() error {
+	path := strings.TrimRight(config.BasePath, "/")
+	if path != "" && !strings.HasPrefix(path, "/") {
+		return errors.New("-base-path must start with /, e.g. /wiki")
+	}
+	if strings.ContainsAny(path, "?#%\\") || strings.Contains(path, "//") {
+		return errors.New("-base-path must be a plain path, e.g. /wiki")
+	}
+	config.BasePath = path
+	return nil
+}
+
+// The templates' base function, for starting their links with
+func basePath() string {
+	return config.BasePath
+}
+
+// The path to hand out for one of the wiki's own, e.g. sitePath("/index") is /wiki/index
+func sitePath(path string) string {
+	return config.BasePath + path
+}
+
+// The path cookies are scoped to, so they aren't sent to other sites behind the same proxy
+func cookiePath() string {
+	return sitePath("/")
+}
+
+// Serve the wiki under the base path, taking it off requests before they're
+// routed. Anything outside it isn't the wiki's, and the base path itself
+// leads to the home page.
+func stripBasePath(h http.Handler) http.Handler {
+	if config.BasePath == "" {
+		return h
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == config.BasePath {
+			target := sitePath("/")
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		path, ok := strings.CutPrefix(r.URL.Path, config.BasePath+"/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + path
+		if r.URL.RawPath != "" {
+			r2.URL.RawPath = "/" + strings.TrimPrefix(r.URL.RawPath, config.BasePath+"/")
+		}
+		h.ServeHTTP(w, r2)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// The wiki's own path for a link it handed out, without the base path
+func withoutBasePath(href string) string {
+	if path, ok := strings.CutPrefix(href, config.BasePath); ok && strings.HasPrefix(path, "/") {
+		return path
+	}
+	return href
+}
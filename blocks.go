@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a block can be set to last, as offered by the admin page; 0 is for good
+var blockDurations = []struct {
+	Label    string
+	Duration time.Duration
+}{
+	{"1 hour", time.Hour},
+	{"1 day", 24 * time.Hour},
+	{"1 week", 7 * 24 * time.Hour},
+	{"30 days", 30 * 24 * time.Hour},
+	{"Never expires", 0},
+}
+
+// Block stops a user, an address or a range of addresses changing the wiki.
+// They can still read it, and they're told why they can't edit.
+type Block struct {
+	ID      string     `json:"id"`
+	Target  string     `json:"target"` // a username, an IP address or a CIDR range
+	Reason  string     `json:"reason"`
+	By      string     `json:"by"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func (b *Block) expired(now time.Time) bool {
+	return b.Expires != nil && now.After(*b.Expires)
+}
+
+// Does the block cover the request's user or address?
+func (b *Block) matches(user string, addr netip.Addr) bool {
+	if prefix, err := netip.ParsePrefix(b.Target); err == nil {
+		return addr.IsValid() && prefix.Contains(addr.Unmap())
+	}
+	if ip, err := netip.ParseAddr(b.Target); err == nil {
+		return addr.IsValid() && ip.Unmap() == addr.Unmap()
+	}
+	return user != "" && b.Target == user
+}
+
+// A block target must be a username or parse as an address or range
+func validBlockTarget(target string) bool {
+	if _, err := netip.ParsePrefix(target); err == nil {
+		return true
+	}
+	if _, err := netip.ParseAddr(target); err == nil {
+		return true
+	}
+	return validUsername.MatchString(target)
+}
+
+// blockStore keeps the blocklist, saved to data/.blocks.json whenever it changes
+type blockStore struct {
+	mu     sync.Mutex
+	blocks []*Block
+}
+
+var blocks = &blockStore{}
+
+func blocksFile() string {
+	return dataPath(".blocks.json")
+}
+
+func (s *blockStore) load() error {
+	data, err := os.ReadFile(blocksFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.Unmarshal(data, &s.blocks)
+}
+
+// Write the blocks back out, leaving expired ones behind; callers must hold the lock
+func (s *blockStore) persist() error {
+	now := time.Now()
+	s.blocks = slices.DeleteFunc(s.blocks, func(b *Block) bool { return b.expired(now) })
+	data, err := json.MarshalIndent(s.blocks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(blocksFile(), data)
+}
+
+func (s *blockStore) add(target, reason, by string, duration time.Duration) (*Block, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	b := &Block{ID: hex.EncodeToString(id), Target: target, Reason: reason, By: by, Created: time.Now().UTC()}
+	if duration > 0 {
+		expires := b.Created.Add(duration)
+		b.Expires = &expires
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks = append(s.blocks, b)
+	return b, s.persist()
+}
+
+// Lift a block, returning it if there was one
+func (s *blockStore) remove(id string) (*Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.blocks, func(b *Block) bool { return b.ID == id })
+	if i < 0 {
+		return nil, nil
+	}
+	b := s.blocks[i]
+	s.blocks = slices.Delete(s.blocks, i, i+1)
+	return b, s.persist()
+}
+
+// The blocks still in force, newest first
+func (s *blockStore) active() []Block {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []Block
+	for i := len(s.blocks) - 1; i >= 0; i-- {
+		if !s.blocks[i].expired(now) {
+			list = append(list, *s.blocks[i])
+		}
+	}
+	return list
+}
+
+// The block stopping the request, if there is one
+func (s *blockStore) find(r *http.Request) *Block {
+	addr, _ := netip.ParseAddr(clientIP(r))
+	user := username(r)
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.blocks {
+		if !b.expired(now) && b.matches(user, addr) {
+			found := *b
+			return &found
+		}
+	}
+	return nil
+}
+
+// Tell a blocked visitor why they can't change anything, and report whether they were
+func refuseBlocked(w http.ResponseWriter, r *http.Request) bool {
+	b := blocks.find(r)
+	if b == nil {
+		return false
+	}
+	w.WriteHeader(http.StatusForbidden)
+	renderTemplate(w, r, "blocked", b)
+	return true
+}
+
+// /admin/blocks lists the blocks in force, and adds and lifts them
+func blocksHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Blocks    []Block
+		Durations any
+		Target    string // filled in from ?target=, as linked from /admin
+		Error     string
+	}{Durations: blockDurations, Target: r.FormValue("target")}
+	if r.Method == http.MethodPost {
+		if id := r.FormValue("lift"); id != "" {
+			b, err := blocks.remove(id)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			if b != nil {
+				audit(r, "block", "", "lifted the block on "+b.Target)
+			}
+			backToAdmin(w, r, "/admin/blocks")
+			return
+		}
+		target := strings.TrimSpace(r.FormValue("target"))
+		reason := strings.TrimSpace(r.FormValue("reason"))
+		duration, err := time.ParseDuration(r.FormValue("duration"))
+		switch {
+		case !validBlockTarget(target):
+			data.Error = "Block a username, an IP address such as 192.0.2.1, or a range such as 192.0.2.0/24."
+		case reason == "":
+			data.Error = "Give a reason: it's shown to whoever is blocked."
+		case err != nil || duration < 0:
+			data.Error = "Pick how long the block lasts."
+		default:
+			if _, err := blocks.add(target, reason, username(r), duration); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			audit(r, "block", "", "blocked "+target+": "+reason)
+			http.Redirect(w, r, sitePath("/admin/blocks"), http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	data.Blocks = blocks.active()
+	renderTemplate(w, r, "blocks", data)
+}
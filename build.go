@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+)
+
+// gowiki build -o public renders the wiki to a static site, ready for any
+// web host: every page anyone may read, with its attachments and an index.
+// It takes the same flags as serving the wiki, for finding the pages.
+func buildCommand(args []string) error {
+	fs := flag.NewFlagSet("gowiki build", flag.ContinueOnError)
+	out := fs.String("o", "public", "directory to write the site to")
+	if _, err := openWiki(fs, args, ""); err != nil {
+		return err
+	}
+	if err := loadTemplates(); err != nil {
+		return err
+	}
+	titles, err := store.List()
+	if err != nil {
+		return err
+	}
+	// the site is for everyone, so restricted pages stay behind
+	var public []string
+	for _, title := range titles {
+		if includable(title) {
+			public = append(public, title)
+		}
+	}
+	l := locales[config.Language]
+	t, err := currentTemplates(l, siteZone)
+	if err != nil {
+		return err
+	}
+	if err := writeExport(context.Background(), dirTarget(*out), t, l, public, true); err != nil {
+		return err
+	}
+	log.Printf("Built %d pages into %s\n", len(public), *out)
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Where a CAPTCHA can be asked for
+const (
+	captchaEdit     = "edit"
+	captchaRegister = "register"
+)
+
+// captcha is a challenge people solve in the browser, which the server then
+// checks with the service that set it
+type captcha interface {
+	// widget is the HTML placing the challenge in a form
+	widget() template.HTML
+	// verify checks the form's answer with the service
+	verify(r *http.Request) error
+}
+
+// hCaptcha, reCAPTCHA and Turnstile all work the same way: a script fills a
+// widget in, which adds its token to the form under a field of their own,
+// and the server posts the token to a siteverify URL with its secret
+type siteverifyCaptcha struct {
+	script    string
+	class     string
+	field     string
+	verifyURL string
+}
+
+var captchaServices = map[string]siteverifyCaptcha{
+	"hcaptcha":  {"https://js.hcaptcha.com/1/api.js", "h-captcha", "h-captcha-response", "https://api.hcaptcha.com/siteverify"},
+	"recaptcha": {"https://www.google.com/recaptcha/api.js", "g-recaptcha", "g-recaptcha-response", "https://www.google.com/recaptcha/api/siteverify"},
+	"turnstile": {"https://challenges.cloudflare.com/turnstile/v0/api.js", "cf-turnstile", "cf-turnstile-response", "https://challenges.cloudflare.com/turnstile/v0/siteverify"},
+}
+
+var (
+	activeCaptcha captcha
+	captchaClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+var errCaptcha = errors.New("the CAPTCHA wasn't solved")
+
+// Check the CAPTCHA settings and pick the service
+func checkCaptcha() error {
+	if config.Captcha == "" {
+		return nil
+	}
+	service, ok := captchaServices[config.Captcha]
+	if !ok {
+		return fmt.Errorf("unknown CAPTCHA %q: use hcaptcha, recaptcha or turnstile", config.Captcha)
+	}
+	if config.CaptchaSiteKey == "" || config.CaptchaSecret == "" {
+		return errors.New("a CAPTCHA needs both -captcha-site-key and -captcha-secret")
+	}
+	for _, place := range config.CaptchaFor {
+		if place != captchaEdit && place != captchaRegister {
+			return fmt.Errorf("-captcha-for takes edit and register, not %q", place)
+		}
+	}
+	activeCaptcha = service
+	return nil
+}
+
+func (c siteverifyCaptcha) widget() template.HTML {
+	return template.HTML(`<script src="` + template.HTMLEscapeString(c.script) + `" async defer></script>` +
+		`<div class="` + c.class + `" data-sitekey="` + template.HTMLEscapeString(config.CaptchaSiteKey) + `"></div>`)
+}
+
+func (c siteverifyCaptcha) verify(r *http.Request) error {
+	answer := r.PostFormValue(c.field)
+	if answer == "" {
+		return errCaptcha
+	}
+	resp, err := captchaClient.PostForm(c.verifyURL, url.Values{
+		"secret":   {config.CaptchaSecret},
+		"response": {answer},
+		"remoteip": {clientIP(r)},
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't check the CAPTCHA: %w", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("couldn't check the CAPTCHA: %w", err)
+	}
+	if !result.Success {
+		return errCaptcha
+	}
+	return nil
+}
+
+// The CAPTCHA to show in a form, if visitors there have to solve one.
+// Logged in users never do.
+func captchaFor(r *http.Request, place string) captcha {
+	if activeCaptcha == nil || currentUser(r) != nil || !slices.Contains(config.CaptchaFor, place) {
+		return nil
+	}
+	return activeCaptcha
+}
+
+func captchaWidget(r *http.Request, place string) template.HTML {
+	if c := captchaFor(r, place); c != nil {
+		return c.widget()
+	}
+	return ""
+}
+
+// Check the form's CAPTCHA, if it needed one
+func checkCaptchaAnswer(r *http.Request, place string) error {
+	if c := captchaFor(r, place); c != nil {
+		return c.verify(r)
+	}
+	return nil
+}
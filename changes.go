@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// How many changes the recent changes page shows
+const recentChangesLimit = 100
+
+// A Change is one entry in the wiki-wide log of edits, appended on every save
+type Change struct {
+	Title    string    `json:"title"`
+	Revision int       `json:"revision"`
+	Time     time.Time `json:"time"`
+	Author   string    `json:"author,omitempty"`
+	Summary  string    `json:"summary,omitempty"`
+}
+
+// Previous is the revision the change was made on top of
+func (c Change) Previous() int {
+	return c.Revision - 1
+}
+
+var changeLogMu sync.Mutex
+
+func changeLogFile() string {
+	return dataPath(".changes.jsonl")
+}
+
+func recordChange(c Change) error {
+	line, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	changeLogMu.Lock()
+	defer changeLogMu.Unlock()
+	f, err := os.OpenFile(changeLogFile(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Go through the change log oldest first, skipping any line that can't be read
+func readChanges(fn func(c Change)) error {
+	changeLogMu.Lock()
+	defer changeLogMu.Unlock()
+	f, err := os.Open(changeLogFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var c Change
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			continue
+		}
+		fn(c)
+	}
+	return scanner.Err()
+}
+
+// Read the change log, newest first, keeping at most limit entries
+func recentChanges(limit int) ([]Change, error) {
+	var changes []Change
+	err := readChanges(func(c Change) {
+		changes = append(changes, c)
+		if len(changes) > limit {
+			changes = changes[1:]
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	return changes, nil
+}
+
+func changesHandler(w http.ResponseWriter, r *http.Request) {
+	changes, err := recentChanges(recentChangesLimit)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	var visible []Change
+	for _, c := range changes {
+		if perm, err := pagePermission(r, c.Title); err == nil && perm >= permRead {
+			visible = append(visible, c)
+		}
+	}
+	renderTemplate(w, r, "changes", visible)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ChatNotifier posts a message to a Slack or Mattermost incoming webhook
+// when pages are saved. With namespaces or tags, it only posts about pages
+// in one of the namespaces or with one of the tags.
+type ChatNotifier struct {
+	URL        string   `yaml:"url"`
+	Namespaces []string `yaml:"namespaces"`
+	Tags       []string `yaml:"tags"`
+}
+
+// Incoming webhook URLs are secret, so the admin page only shows where they go
+func (n ChatNotifier) target() string {
+	u, err := url.Parse(n.URL)
+	if err != nil {
+		return "chat"
+	}
+	return "chat at " + u.Host
+}
+
+func (n ChatNotifier) wants(title string, pageTags []string) bool {
+	if len(n.Namespaces) == 0 && len(n.Tags) == 0 {
+		return true
+	}
+	for _, ns := range n.Namespaces {
+		if title == ns || strings.HasPrefix(title, ns+namespaceSeparator) {
+			return true
+		}
+	}
+	return slices.ContainsFunc(n.Tags, func(tag string) bool { return slices.Contains(pageTags, tag) })
+}
+
+// Check each notifier has a URL to post to, and tidy its tags the way pages' tags are
+func checkChatNotifiers(notifiers []ChatNotifier) error {
+	for i := range notifiers {
+		n := &notifiers[i]
+		if u, err := url.Parse(n.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("chat notifier URL %q must be an http or https URL", n.URL)
+		}
+		for j, tag := range n.Tags {
+			normalized, ok := normalizeTag(tag)
+			if !ok {
+				return fmt.Errorf("chat notifier %s has an invalid tag %q", n.target(), tag)
+			}
+			n.Tags[j] = normalized
+		}
+	}
+	return nil
+}
+
+// The JSON Slack and Mattermost incoming webhooks take
+type chatMessage struct {
+	Text string `json:"text"`
+}
+
+// Both understand the same markup: *bold*, _italics_ and <url|text> links,
+// with &, < and > escaped everywhere else
+var chatEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func chatText(event string, c Change) string {
+	author := "Someone"
+	if c.Author != "" {
+		author = "*" + chatEscaper.Replace(c.Author) + "*"
+	}
+	text := author + " " + event + " <" + siteURL() + pageURL("view", c.Title) + "|" + chatEscaper.Replace(c.Title) + ">"
+	if c.Summary != "" {
+		text += ": _" + chatEscaper.Replace(c.Summary) + "_"
+	}
+	if c.Revision > 1 {
+		text += " (<" + siteURL() + pageURL("diff", c.Title) + "/" + strconv.Itoa(c.Previous()) + "/" + strconv.Itoa(c.Revision) + "|diff>)"
+	}
+	return text
+}
+
+// Post a saved page to every chat notifier wanting it
+func notifyChat(event string, c Change, body []byte) {
+	if len(config.ChatNotifiers) == 0 {
+		return
+	}
+	found := pageTags(body)
+	for _, n := range config.ChatNotifiers {
+		if !n.wants(c.Title, found) {
+			continue
+		}
+		queueDelivery(&webhookDelivery{Target: n.target(), Event: webhookEvent{Event: event, Title: c.Title, Author: c.Author, Revision: c.Revision, Time: c.Time},
+			url: n.URL, payload: chatMessage{Text: chatText(event, c)}})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// The subcommands, each given the arguments after its name. The page
+// commands work on the storage directly, so scripts can manage content
+// without going through HTTP. A running wiki keeps its indexes in memory,
+// so it only sees their changes in links and tags once restarted.
+var commands = map[string]func(args []string) error{
+	"serve": serveCommand,
+	"build": buildCommand,
+	"list":  listCommand,
+	"cat":   catCommand,
+	"put":   putCommand,
+	"rm":    rmCommand,
+}
+
+const usage = `usage: gowiki [command] [flags] [arguments]
+
+Commands:
+  serve                  run the wiki (the default)
+  build [-o dir]         render the public pages to a static site
+  list                   list every page's title
+  cat <title>            print a page's source
+  put <title> <file>     save a page from a file, or - for standard input
+  rm <title>             move a page to the trash
+
+Every command takes the flags serve does, for finding the pages; run
+gowiki <command> -h to list them.
+`
+
+func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		fmt.Print(usage)
+		return
+	}
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := command(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		log.Fatalf("gowiki %s: %s\n", name, err.Error())
+	}
+}
+
+// Load the configuration and open the pages for a command working on
+// them, returning its arguments. Params names the arguments the command
+// wants; a first one of <title> must be a valid title.
+func openWiki(fs *flag.FlagSet, args []string, params string) ([]string, error) {
+	if err := loadConfig(fs, args); err != nil {
+		return nil, err
+	}
+	want := strings.Fields(params)
+	if fs.NArg() != len(want) {
+		return nil, errors.New(strings.TrimSpace("usage: " + fs.Name() + " [flags] " + params))
+	}
+	if len(want) > 0 && want[0] == "<title>" && !validTitle(fs.Arg(0)) {
+		return nil, fmt.Errorf("%q isn't a valid page title", fs.Arg(0))
+	}
+	if err := openStore(); err != nil {
+		return nil, err
+	}
+	renders = newRenderCache(0)
+	if err := buildIndexes(); err != nil {
+		return nil, err
+	}
+	// saves notify watchers, who may want mail
+	if err := watches.load(); err != nil {
+		return nil, err
+	}
+	users.path = config.UsersFile
+	if err := users.load(); err != nil {
+		return nil, err
+	}
+	return fs.Args(), nil
+}
+
+func listCommand(args []string) error {
+	if _, err := openWiki(flag.NewFlagSet("gowiki list", flag.ContinueOnError), args, ""); err != nil {
+		return err
+	}
+	titles, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, title := range titles {
+		fmt.Println(title)
+	}
+	return nil
+}
+
+func catCommand(args []string) error {
+	args, err := openWiki(flag.NewFlagSet("gowiki cat", flag.ContinueOnError), args, "<title>")
+	if err != nil {
+		return err
+	}
+	p, err := loadPage(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(p.Body)
+	return err
+}
+
+func putCommand(args []string) error {
+	fs := flag.NewFlagSet("gowiki put", flag.ContinueOnError)
+	summary := fs.String("m", "", "summary of the change")
+	author := fs.String("author", "", "who the change is recorded as being by")
+	args, err := openWiki(fs, args, "<title> <file>")
+	if err != nil {
+		return err
+	}
+	var body []byte
+	if args[1] == "-" {
+		body, err = io.ReadAll(os.Stdin)
+	} else {
+		body, err = os.ReadFile(args[1])
+	}
+	if err != nil {
+		return err
+	}
+	p := &Page{Title: args[0], Body: body}
+	return p.save(context.Background(), Edit{Author: *author, Summary: strings.Join(strings.Fields(*summary), " ")})
+}
+
+func rmCommand(args []string) error {
+	fs := flag.NewFlagSet("gowiki rm", flag.ContinueOnError)
+	summary := fs.String("m", "", "summary of the change")
+	author := fs.String("author", "", "who the change is recorded as being by")
+	args, err := openWiki(fs, args, "<title>")
+	if err != nil {
+		return err
+	}
+	if !pageExists(args[0]) {
+		return fmt.Errorf("there's no page called %s", args[0])
+	}
+	return deletePage(context.Background(), args[0], Edit{Author: *author, Summary: strings.Join(strings.Fields(*summary), " ")})
+}
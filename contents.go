@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// How many pages the contents lists at a time
+const indexPageSize = 100
+
+// An entry on the contents when sorted by when pages changed
+type modifiedPage struct {
+	Title    string
+	Modified time.Time
+}
+
+// One page of the contents, with what's needed to link to the others
+type contentsPage struct {
+	Sort     string
+	Prefix   string // only titles starting with this are listed
+	Page     int
+	Pages    int
+	Tree     []titlePart
+	Modified []modifiedPage
+}
+
+func (c contentsPage) link(page int) string {
+	v := url.Values{}
+	if c.Sort != "title" {
+		v.Set("sort", c.Sort)
+	}
+	if c.Prefix != "" {
+		v.Set("prefix", c.Prefix)
+	}
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	if len(v) == 0 {
+		return sitePath("/index")
+	}
+	return sitePath("/index?" + v.Encode())
+}
+
+// The first page of the contents sorted another way, keeping to the same prefix
+func (c contentsPage) SortLink(sort string) string {
+	c.Sort = sort
+	return c.link(1)
+}
+
+// The first page of every page's contents, sorted the same way
+func (c contentsPage) Unfiltered() string {
+	c.Prefix = ""
+	return c.link(1)
+}
+
+// Links to the neighbouring pages, empty at either end
+func (c contentsPage) Prev() string {
+	if c.Page <= 1 {
+		return ""
+	}
+	return c.link(c.Page - 1)
+}
+
+func (c contentsPage) Next() string {
+	if c.Page >= c.Pages {
+		return ""
+	}
+	return c.link(c.Page + 1)
+}
+
+// Go through the pages the request can read, sorted as asked, by title in
+// namespaces or most recently modified first, and pick out the requested page
+// of them. Sorted by title, only that page is kept as the store is walked, so
+// the contents of a big wiki doesn't mean holding every title at once. The
+// tags the pages use are counted on the way.
+func listContents(r *http.Request) (contentsPage, map[string]int, error) {
+	c := contentsPage{Sort: r.FormValue("sort"), Prefix: r.FormValue("prefix")}
+	if c.Sort != "modified" {
+		c.Sort = "title"
+	}
+	want, _ := strconv.Atoi(r.FormValue("page"))
+	want = max(want, 1) - 1
+	counts := make(map[string]int)
+	var chunk []string
+	var modified []modifiedPage
+	n := 0
+	err := walkPages(c.Prefix, func(title string) error {
+		if perm, err := pagePermission(r, title); err != nil || perm < permRead {
+			return nil
+		}
+		tags.count(counts, title)
+		if c.Sort == "modified" {
+			modified = append(modified, modifiedPage{title, pageModTime(title)})
+		} else if n/indexPageSize <= want {
+			// past the end this leaves the last page, which is what's shown then
+			if n%indexPageSize == 0 {
+				chunk = chunk[:0]
+			}
+			chunk = append(chunk, title)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return c, nil, err
+	}
+	c.Pages = max(1, (n+indexPageSize-1)/indexPageSize)
+	c.Page = min(want+1, c.Pages)
+	if c.Sort == "title" {
+		c.Tree = titleTree(chunk)
+		return c, counts, nil
+	}
+	sort.SliceStable(modified, func(i, j int) bool { return modified[i].Modified.After(modified[j].Modified) })
+	from := (c.Page - 1) * indexPageSize
+	c.Modified = modified[from:min(from+indexPageSize, n)]
+	return c, counts, nil
+}
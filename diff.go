@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffOp describes what happened to a single line between two versions
+type diffOp int
+
+const (
+	diffContext diffOp = iota
+	diffDelete
+	diffInsert
+)
+
+type diffLine struct {
+	Op   diffOp
+	Text string
+}
+
+// Prefix returns the unified diff marker for the line
+func (l diffLine) Prefix() string {
+	switch l.Op {
+	case diffDelete:
+		return "-"
+	case diffInsert:
+		return "+"
+	}
+	return " "
+}
+
+// Class returns a CSS class name for the line, used by the diff template
+func (l diffLine) Class() string {
+	switch l.Op {
+	case diffDelete:
+		return "delete"
+	case diffInsert:
+		return "insert"
+	}
+	return "context"
+}
+
+// A diffHunk is a run of changed lines plus surrounding context
+type diffHunk struct {
+	FromLine, FromCount int
+	ToLine, ToCount     int
+	Lines               []diffLine
+}
+
+func (h diffHunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.FromLine, h.FromCount, h.ToLine, h.ToCount)
+}
+
+// Split a page body into lines, normalising the CRLFs browsers submit
+func splitLines(body []byte) []string {
+	s := strings.ReplaceAll(string(body), "\r\n", "\n")
+	if s == "" {
+		return nil
+	}
+	s = strings.TrimSuffix(s, "\n")
+	return strings.Split(s, "\n")
+}
+
+// Compute a line-by-line edit script from a to b using a longest common subsequence
+func diffLines(a, b []string) []diffLine {
+	// trim the common prefix and suffix so the LCS table stays small
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []diffLine
+	for _, s := range a[:prefix] {
+		lines = append(lines, diffLine{diffContext, s})
+	}
+
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(ma) && j < len(mb) {
+		switch {
+		case ma[i] == mb[j]:
+			lines = append(lines, diffLine{diffContext, ma[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{diffDelete, ma[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{diffInsert, mb[j]})
+			j++
+		}
+	}
+	for ; i < len(ma); i++ {
+		lines = append(lines, diffLine{diffDelete, ma[i]})
+	}
+	for ; j < len(mb); j++ {
+		lines = append(lines, diffLine{diffInsert, mb[j]})
+	}
+
+	for _, s := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{diffContext, s})
+	}
+	return lines
+}
+
+// Group the edit script from a to b into hunks with the given number of context lines
+func unifiedDiff(a, b []string, context int) []diffHunk {
+	lines := diffLines(a, b)
+
+	// line numbers in a and b just before each entry of the edit script
+	type position struct{ a, b int }
+	positions := make([]position, len(lines)+1)
+	ai, bi := 0, 0
+	for i, l := range lines {
+		positions[i] = position{ai, bi}
+		switch l.Op {
+		case diffContext:
+			ai++
+			bi++
+		case diffDelete:
+			ai++
+		case diffInsert:
+			bi++
+		}
+	}
+	positions[len(lines)] = position{ai, bi}
+
+	var hunks []diffHunk
+	for i := 0; i < len(lines); {
+		if lines[i].Op == diffContext {
+			i++
+			continue
+		}
+
+		// extend the hunk while the gaps between changes are small enough to share context
+		start := max(i-context, 0)
+		end := i
+		for end < len(lines) {
+			if lines[end].Op != diffContext {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].Op == diffContext {
+				next++
+			}
+			if next == len(lines) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		stop := min(end+context, len(lines))
+
+		h := diffHunk{
+			FromLine:  positions[start].a + 1,
+			FromCount: positions[stop].a - positions[start].a,
+			ToLine:    positions[start].b + 1,
+			ToCount:   positions[stop].b - positions[start].b,
+			Lines:     lines[start:stop],
+		}
+		// an empty range is numbered by the line before it
+		if h.FromCount == 0 {
+			h.FromLine--
+		}
+		if h.ToCount == 0 {
+			h.ToLine--
+		}
+		hunks = append(hunks, h)
+		i = stop
+	}
+	return hunks
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A draft is the unsaved state of someone's edit, kept so a crashed browser
+// or a lost session doesn't lose the work. Each user has at most one per page.
+type Draft struct {
+	Body    string    `json:"body"`
+	Summary string    `json:"summary,omitempty"`
+	Saved   time.Time `json:"saved"`
+}
+
+func draftFile(title, user string) string {
+	return dataPath(".drafts", titleFileName(title), user+".json")
+}
+
+func loadDraft(title, user string) (*Draft, error) {
+	data, err := os.ReadFile(draftFile(title, user))
+	if err != nil {
+		return nil, err
+	}
+	draft := &Draft{}
+	return draft, json.Unmarshal(data, draft)
+}
+
+func saveDraft(title, user string, draft *Draft) error {
+	if err := os.MkdirAll(filepath.Dir(draftFile(title, user)), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(draft)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(draftFile(title, user), data, 0600)
+}
+
+func discardDraft(title, user string) error {
+	if err := os.Remove(draftFile(title, user)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// The edit page posts the form here as the user types. Posting discard
+// throws the draft away instead.
+func draftHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "That page can only be reached by submitting a form.")
+		return
+	}
+	user := username(r)
+	if r.FormValue("discard") != "" {
+		if err := discardDraft(title, user); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, pageURL("edit", title), http.StatusFound)
+		return
+	}
+	draft := &Draft{Body: r.FormValue("body"), Summary: r.FormValue("summary"), Saved: time.Now().UTC()}
+	if err := saveDraft(title, user, draft); err != nil {
+		serverError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
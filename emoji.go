@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// How many suggestions /emoji.json gives at most
+const emojiSuggestions = 20
+
+var emojiShortcode = regexp.MustCompile(`:([a-z0-9_+-]+):`)
+
+// The shortcodes pages can use, named as on GitHub and Slack
+var emoji = map[string]string{
+	"+1":                       "👍",
+	"-1":                       "👎",
+	"100":                      "💯",
+	"alarm_clock":              "⏰",
+	"angry":                    "😠",
+	"apple":                    "🍎",
+	"arrow_down":               "⬇️",
+	"arrow_left":               "⬅️",
+	"arrow_right":              "➡️",
+	"arrow_up":                 "⬆️",
+	"baby":                     "👶",
+	"balloon":                  "🎈",
+	"bangbang":                 "‼️",
+	"beer":                     "🍺",
+	"bell":                     "🔔",
+	"bike":                     "🚲",
+	"birthday":                 "🎂",
+	"blush":                    "😊",
+	"bomb":                     "💣",
+	"book":                     "📖",
+	"bookmark":                 "🔖",
+	"books":                    "📚",
+	"boom":                     "💥",
+	"bug":                      "🐛",
+	"bulb":                     "💡",
+	"bus":                      "🚌",
+	"cake":                     "🍰",
+	"calendar":                 "📆",
+	"camera":                   "📷",
+	"car":                      "🚗",
+	"cat":                      "🐱",
+	"chart_with_upwards_trend": "📈",
+	"clap":                     "👏",
+	"clipboard":                "📋",
+	"clock":                    "🕒",
+	"cloud":                    "☁️",
+	"coffee":                   "☕",
+	"computer":                 "💻",
+	"confused":                 "😕",
+	"construction":             "🚧",
+	"cool":                     "🆒",
+	"cry":                      "😢",
+	"dog":                      "🐶",
+	"door":                     "🚪",
+	"email":                    "📧",
+	"exclamation":              "❗",
+	"eyes":                     "👀",
+	"facepalm":                 "🤦",
+	"file_folder":              "📁",
+	"fire":                     "🔥",
+	"flag":                     "🚩",
+	"flushed":                  "😳",
+	"gear":                     "⚙️",
+	"gift":                     "🎁",
+	"globe_with_meridians":     "🌐",
+	"grimacing":                "😬",
+	"grin":                     "😁",
+	"grinning":                 "😀",
+	"hammer":                   "🔨",
+	"hand":                     "✋",
+	"heart":                    "❤️",
+	"heart_eyes":               "😍",
+	"heavy_check_mark":         "✔️",
+	"hourglass":                "⌛",
+	"house":                    "🏠",
+	"hugs":                     "🤗",
+	"information_source":       "ℹ️",
+	"joy":                      "😂",
+	"key":                      "🔑",
+	"kissing":                  "😗",
+	"laughing":                 "😆",
+	"link":                     "🔗",
+	"lock":                     "🔒",
+	"loudspeaker":              "📢",
+	"mag":                      "🔍",
+	"mailbox":                  "📫",
+	"memo":                     "📝",
+	"moon":                     "🌙",
+	"muscle":                   "💪",
+	"neutral_face":             "😐",
+	"no_entry":                 "⛔",
+	"no_entry_sign":            "🚫",
+	"ok":                       "🆗",
+	"ok_hand":                  "👌",
+	"package":                  "📦",
+	"pencil":                   "📝",
+	"pencil2":                  "✏️",
+	"phone":                    "☎️",
+	"pizza":                    "🍕",
+	"point_down":               "👇",
+	"point_left":               "👈",
+	"point_right":              "👉",
+	"point_up":                 "☝️",
+	"pray":                     "🙏",
+	"pushpin":                  "📌",
+	"question":                 "❓",
+	"rabbit":                   "🐰",
+	"rage":                     "😡",
+	"rainbow":                  "🌈",
+	"raised_hands":             "🙌",
+	"recycle":                  "♻️",
+	"relaxed":                  "☺️",
+	"relieved":                 "😌",
+	"rocket":                   "🚀",
+	"rotating_light":           "🚨",
+	"scissors":                 "✂️",
+	"scream":                   "😱",
+	"see_no_evil":              "🙈",
+	"shrug":                    "🤷",
+	"skull":                    "💀",
+	"sleeping":                 "😴",
+	"slightly_smiling_face":    "🙂",
+	"smile":                    "😄",
+	"smiley":                   "😃",
+	"smirk":                    "😏",
+	"snowflake":                "❄️",
+	"sob":                      "😭",
+	"sparkles":                 "✨",
+	"speech_balloon":           "💬",
+	"star":                     "⭐",
+	"stopwatch":                "⏱️",
+	"sun":                      "☀️",
+	"sunglasses":               "😎",
+	"sweat_smile":              "😅",
+	"tada":                     "🎉",
+	"thinking":                 "🤔",
+	"thumbsdown":               "👎",
+	"thumbsup":                 "👍",
+	"partying_face":            "🥳",
+	"trophy":                   "🏆",
+	"umbrella":                 "☂️",
+	"unamused":                 "😒",
+	"unlock":                   "🔓",
+	"warning":                  "⚠️",
+	"wave":                     "👋",
+	"white_check_mark":         "✅",
+	"wink":                     "😉",
+	"wrench":                   "🔧",
+	"x":                        "❌",
+	"yum":                      "😋",
+	"zap":                      "⚡",
+	"zzz":                      "💤",
+}
+
+// Replace known :shortcode:s with their emoji, leaving anything else between colons alone
+func renderEmoji(out []byte) []byte {
+	return emojiShortcode.ReplaceAllFunc(out, func(code []byte) []byte {
+		name := string(emojiShortcode.FindSubmatch(code)[1])
+		e, ok := emoji[name]
+		if !ok {
+			return code
+		}
+		return []byte(`<span class="emoji" title=":` + name + `:">` + e + `</span>`)
+	})
+}
+
+type emojiSuggestion struct {
+	Name  string `json:"name"`
+	Emoji string `json:"emoji"`
+}
+
+// GET /emoji.json?q=sm suggests the shortcodes starting with what's been typed,
+// then those containing it, for the editor to offer
+func emojiHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.ToLower(strings.Trim(r.FormValue("q"), ":"))
+	var prefixed, containing []emojiSuggestion
+	for name, e := range emoji {
+		switch {
+		case strings.HasPrefix(name, q):
+			prefixed = append(prefixed, emojiSuggestion{name, e})
+		case strings.Contains(name, q):
+			containing = append(containing, emojiSuggestion{name, e})
+		}
+	}
+	byName := func(list []emojiSuggestion) {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+	byName(prefixed)
+	byName(containing)
+	suggestions := append(prefixed, containing...)
+	if suggestions == nil {
+		suggestions = []emojiSuggestion{}
+	}
+	w.Header().Set("Cache-Control", "public, max-age="+staticMaxAge)
+	writeJSON(w, http.StatusOK, suggestions[:min(len(suggestions), emojiSuggestions)])
+}
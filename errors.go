@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// What the error page shows. Create is set on a 404 for a page that could be
+// written. RequestID is the reference to quote when reporting the error.
+type errorData struct {
+	Status     int
+	StatusText string
+	Message    string
+	RequestID  string
+	Title      string
+	Create     bool
+}
+
+// Render the error page with the given status and an explanation for the visitor
+func httpError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.WriteHeader(status)
+	renderTemplate(w, r, "error", errorData{Status: status, StatusText: http.StatusText(status), Message: message, RequestID: requestID(r)})
+}
+
+// A 404 for a page URL offers to create the page instead
+func notFound(w http.ResponseWriter, r *http.Request) {
+	data := errorData{Status: http.StatusNotFound, StatusText: http.StatusText(http.StatusNotFound), RequestID: requestID(r)}
+	if m := validPath.FindStringSubmatch(r.URL.Path); m != nil && validTitle(m[2]) && !pageExists(m[2]) {
+		data.Title, data.Create = m[2], true
+	}
+	w.WriteHeader(http.StatusNotFound)
+	renderTemplate(w, r, "error", data)
+}
+
+// Something went wrong on our side. The details go to the log under the
+// request's ID, which the visitor can quote, rather than onto the page.
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	id := requestID(r)
+	if id == "" {
+		id = newRequestID()
+	}
+	log.Printf("Request %s for %s failed: %s\n", id, r.URL.Path, err.Error())
+	if timedOut(err) {
+		httpError(w, r, http.StatusServiceUnavailable, "The wiki took too long to answer, so it gave up. Trying again in a little while may help.")
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	renderTemplate(w, r, "error", errorData{
+		Status:     http.StatusInternalServerError,
+		StatusText: http.StatusText(http.StatusInternalServerError),
+		RequestID:  id,
+	})
+}
+
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Compute a weak ETag for a view from everything on it that matters,
+// including who's looking, in what language and time zone. It's weak because
+// the view count moves on with every request and isn't included.
+func viewETag(user, lang, zone string, data viewData) string {
+	h := sha256.New()
+	for _, part := range []string{user, lang, zone, data.Title, data.DisplayTitle(), strings.Join(data.MetaTags(), ","), data.ModTime.String(), data.LastEditor, string(data.HTML), string(data.Sidebar), strconv.FormatBool(data.Watching), strconv.Itoa(data.Comments), strconv.FormatBool(data.System), data.RedirectedFrom, strconv.FormatBool(data.BrokenRedirect)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	for _, title := range data.Backlinks {
+		h.Write([]byte(title))
+		h.Write([]byte{0})
+	}
+	for _, child := range data.Children {
+		h.Write([]byte(child.Title))
+		h.Write([]byte{0})
+	}
+	for _, crumb := range data.Breadcrumbs {
+		if crumb.Exists {
+			h.Write([]byte(crumb.Title))
+		}
+		h.Write([]byte{0})
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// Does If-None-Match list the ETag? Weak comparison, as GET calls for.
+func etagMatches(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Send the ETag and caching headers, and a 304 if the client has this
+// version already. Views differ between users, so only the browser may keep
+// them, and it has to check back each time.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Add("Vary", "Cookie, Accept-Language")
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Links between pages in a rendered export, rewritten to point at the exported files
+var exportLink = regexp.MustCompile(`^/(view|attachments)/(.+)$`)
+
+// Stream a zip of every page and its attachments. By default pages are
+// exported as their raw source, ready to be imported again; format=html
+// renders them instead, with the links between them working offline.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.FormValue("format")
+	if format == "" {
+		format = "raw"
+	}
+	if format != "raw" && format != "html" {
+		httpError(w, r, http.StatusBadRequest, "The export format must be raw or html.")
+		return
+	}
+	titles, err := store.List()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	l := requestLocale(r)
+	t, err := currentTemplates(l, requestZone(r))
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	name := "gowiki-" + time.Now().Format("2006-01-02") + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	// the headers are gone by the time anything fails, so all we can do is log and cut the download short
+	zw := zip.NewWriter(w)
+	err = writeExport(r.Context(), zipTarget{zw}, t, l, titles, format == "html")
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.Printf("Export failed: %s\n", err.Error())
+	}
+}
+
+// Write the export archive. Entries are named after the titles themselves
+// rather than their encoded file names, so the archive reads naturally when unpacked.
+func writeExport(ctx context.Context, zw exportTarget, t *template.Template, l *locale, titles []string, rendered bool) error {
+	for _, title := range titles {
+		p, err := loadPage(ctx, title)
+		if err != nil {
+			return err
+		}
+		if rendered {
+			root := exportRoot(title)
+			err = exportRendered(zw, t, title+".html", title, root, exportLinks(p.HTML(l), root))
+		} else {
+			err = exportFile(zw, title+".txt", bytes.NewReader(p.Body))
+		}
+		if err != nil {
+			return err
+		}
+		if err := exportAttachments(zw, title); err != nil {
+			return err
+		}
+	}
+	if rendered {
+		if err := exportRendered(zw, t, "index.html", "Contents", "", exportIndex(titles)); err != nil {
+			return err
+		}
+		css, err := staticFS().Open("wiki.css")
+		if err != nil {
+			return err
+		}
+		defer css.Close()
+		if err := exportFile(zw, "wiki.css", css); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportTarget is where an export's files go: a zip archive for
+// downloads, or a directory for a static site
+type exportTarget interface {
+	create(name string) (io.WriteCloser, error)
+}
+
+type zipTarget struct{ zw *zip.Writer }
+
+type zipEntry struct{ io.Writer }
+
+func (zipEntry) Close() error { return nil }
+
+// Add an entry stamped with the time of the export
+func (z zipTarget) create(name string) (io.WriteCloser, error) {
+	f, err := z.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	return zipEntry{f}, err
+}
+
+// dirTarget writes the export's files under a directory, making the
+// directories namespaced pages and attachments need
+type dirTarget string
+
+func (d dirTarget) create(name string) (io.WriteCloser, error) {
+	path := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+func exportFile(zw exportTarget, name string, r io.Reader) error {
+	f, err := zw.create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Render a page of the export. Root leads from the page back to the top of
+// the archive, for pages in namespaces.
+func exportRendered(zw exportTarget, t *template.Template, name, title, root string, body template.HTML) error {
+	f, err := zw.create(name)
+	if err != nil {
+		return err
+	}
+	err = t.ExecuteTemplate(f, "export.html", struct {
+		Title string
+		Root  string
+		Body  template.HTML
+	}{title, root, body})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Pages in namespaces are in directories of the archive, so need to climb out of them
+func exportRoot(title string) string {
+	return strings.Repeat("../", len(titleNamespaces(title)))
+}
+
+func exportAttachments(zw exportTarget, title string) error {
+	names, err := listAttachments(title)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		f, _, err := attachments.open(title, name)
+		if err != nil {
+			return err
+		}
+		err = exportFile(zw, "attachments/"+title+"/"+name, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Point links to other pages and to attachments at the files beside them in the archive
+func exportLinks(html template.HTML, root string) template.HTML {
+	return template.HTML(wikiURL.ReplaceAllStringFunc(string(html), func(link string) string {
+		m := wikiURL.FindStringSubmatch(link)
+		e := exportLink.FindStringSubmatch(withoutBasePath(m[2]))
+		switch {
+		case e == nil:
+			return link
+		case e[1] == "view":
+			return m[1] + `="` + root + e[2] + `.html"`
+		}
+		return m[1] + `="` + root + `attachments/` + e[2] + `"`
+	}))
+}
+
+func exportIndex(titles []string) template.HTML {
+	var b bytes.Buffer
+	b.WriteString("<ul>\n")
+	for _, title := range titles {
+		b.WriteString(`<li><a href="` + titlePath(title) + `.html">` + template.HTMLEscapeString(title) + "</a></li>\n")
+	}
+	b.WriteString("</ul>\n")
+	return template.HTML(b.String())
+}
+
+// Links and images in a page's HTML that point back into the wiki
+var wikiURL = regexp.MustCompile(`(href|src)="(/[^"]*)"`)
+
+// /export/html/<title> downloads a page as a single HTML file that works on
+// its own, for mailing or archiving: the wiki's styles are inlined, images
+// are embedded as data URIs and links lead back to the wiki. Foundation is
+// left out, as it would need fetching.
+func standaloneHandler(w http.ResponseWriter, r *http.Request) {
+	title := strings.TrimPrefix(r.URL.Path, "/export/html/")
+	if !validTitle(title) {
+		notFound(w, r)
+		return
+	}
+	if !checkPermission(w, r, title, permRead) {
+		return
+	}
+	p, err := loadPage(r.Context(), title)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	l := requestLocale(r)
+	t, err := currentTemplates(l, requestZone(r))
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	var css bytes.Buffer
+	for _, name := range []string{"wiki.css", path.Join("themes", currentTheme(r)+".css")} {
+		data, err := fs.ReadFile(staticFS(), name)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		css.Write(data)
+		css.WriteString("\n")
+	}
+	var buf bytes.Buffer
+	err = t.ExecuteTemplate(&buf, "standalone.html", struct {
+		Title string
+		URL   string
+		CSS   template.CSS
+		Body  template.HTML
+	}{title, siteURL() + pageURL("view", title), template.CSS(css.String()), inlineAssets(p.HTML(l))})
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	name := strings.ReplaceAll(title, "/", "-") + ".html"
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Write(buf.Bytes())
+}
+
+// Embed attachments as data URIs and make every other link to the wiki absolute
+func inlineAssets(html template.HTML) template.HTML {
+	return template.HTML(wikiURL.ReplaceAllStringFunc(string(html), func(link string) string {
+		m := wikiURL.FindStringSubmatch(link)
+		if a := attachmentPath.FindStringSubmatch(withoutBasePath(m[2])); a != nil && m[1] == "src" {
+			if uri, ok := attachmentDataURI(a[1], a[2]); ok {
+				return `src="` + uri + `"`
+			}
+		}
+		return m[1] + `="` + siteURL() + m[2] + `"`
+	}))
+}
+
+func attachmentDataURI(title, name string) (string, bool) {
+	f, _, err := openAttachment(title, name)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", false
+	}
+	return "data:" + attachmentTypes[strings.ToLower(filepath.Ext(name))] + ";base64," + base64.StdEncoding.EncodeToString(data), true
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// How many changes the Atom feed carries
+const feedLimit = 50
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  *atomPerson `xml:"author,omitempty"`
+	Link    atomLink    `xml:"link"`
+	Summary atomText    `xml:"summary"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// Build an absolute URL for links leaving the site, such as in feeds
+func absoluteURL(r *http.Request, path string) string {
+	return requestScheme(r) + "://" + r.Host + path
+}
+
+// Where to look at a change: the diff against the revision before it, or the page itself when it's new
+func changeLink(c Change) string {
+	if c.Revision > 1 {
+		return pageURL("diff", c.Title) + "/" + strconv.Itoa(c.Previous()) + "/" + strconv.Itoa(c.Revision)
+	}
+	return pageURL("view", c.Title)
+}
+
+// What a change did, in the locale's words, when its author didn't say
+func changeSummary(l *locale, c Change) string {
+	if c.Summary != "" {
+		return c.Summary
+	}
+	author := c.Author
+	if author == "" {
+		author = l.translate("an anonymous user")
+	}
+	if c.Revision == 1 {
+		return l.translate("%s created by %s", c.Title, author)
+	}
+	return l.translate("Revision %d of %s by %s", c.Revision, c.Title, author)
+}
+
+func feedHandler(w http.ResponseWriter, r *http.Request) {
+	changes, err := recentChanges(feedLimit)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	l := requestLocale(r)
+	feed := atomFeed{
+		Title:   l.translate("Recent changes"),
+		ID:      absoluteURL(r, sitePath("/changes")),
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  atomPerson{Name: "gowiki"},
+		Links: []atomLink{
+			{Href: absoluteURL(r, sitePath("/changes.atom")), Rel: "self", Type: "application/atom+xml"},
+			{Href: absoluteURL(r, sitePath("/changes")), Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for _, c := range changes {
+		if perm, err := pagePermission(r, c.Title); err != nil || perm < permRead {
+			continue
+		}
+		entry := atomEntry{
+			Title:   c.Title,
+			ID:      absoluteURL(r, pageURL("history", c.Title)+"#"+strconv.Itoa(c.Revision)),
+			Updated: c.Time.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: absoluteURL(r, changeLink(c)), Rel: "alternate", Type: "text/html"},
+			Summary: atomText{Type: "text", Body: changeSummary(l, c)},
+		}
+		if c.Author != "" {
+			entry.Author = &atomPerson{Name: c.Author}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	// the feed was last updated by its newest entry
+	if len(feed.Entries) > 0 {
+		feed.Updated = feed.Entries[0].Updated
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		serverError(w, r, err)
+	}
+}
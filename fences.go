@@ -0,0 +1,33 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A fenced block: a line of ``` with an optional language, the code, and a closing ``` line
+var fencedBlock = regexp.MustCompile("(?ms)^```[ \t]*([a-zA-Z0-9_+-]*)[ \t]*\r?\n(.*?)\r?\n```[ \t]*\r?$")
+
+// Languages drawn as diagrams in the browser, by static/diagrams.js with the
+// libraries in static/vendor, rather than shown as code
+var diagramLanguages = map[string]string{
+	"mermaid":  "mermaid",
+	"graphviz": "graphviz",
+	"dot":      "graphviz",
+}
+
+// Set fenced code blocks aside, shown as they were typed, except for
+// mermaid and graphviz blocks, which become diagrams
+func extractFences(escaped []byte, held *placeholders) []byte {
+	return fencedBlock.ReplaceAllFunc(escaped, func(block []byte) []byte {
+		m := fencedBlock.FindSubmatch(block)
+		lang, code := strings.ToLower(string(m[1])), string(m[2])
+		if kind, ok := diagramLanguages[lang]; ok {
+			return held.mark(`<pre class="diagram diagram-` + kind + `">` + code + `</pre>`)
+		}
+		if lang != "" {
+			return held.mark(`<pre><code class="language-` + lang + `">` + code + `</code></pre>`)
+		}
+		return held.mark(`<pre><code>` + code + `</code></pre>`)
+	})
+}
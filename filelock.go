@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Lock a page while it's changed, so saves from other goroutines, or other
+// wiki processes sharing the data directory, wait their turn instead of
+// interleaving their writes. The lock files live under .locks.
+func lockPage(title string) (unlock func(), err error) {
+	dir := dataPath(".locks")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, titleFileName(title)+".lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// Write a file by writing a temporary one beside it, flushing it to disk and
+// renaming it into place. Readers see the old contents or the new, and a
+// crash part way through leaves the old file as it was.
+func writeFileAtomic(name string, data []byte) error {
+	err := writeTemp(name, data)
+	// the errors name the temporary file, which means nothing to anyone
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &pathErr):
+		err = pathErr.Err
+	case errors.As(err, &linkErr):
+		err = linkErr.Err
+	}
+	return fmt.Errorf("couldn't write %s: %w", name, err)
+}
+
+func writeTemp(name string, data []byte) error {
+	dir := filepath.Dir(name)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	// the rename is only durable once the directory is flushed too; not every
+	// system can open a directory to do that, so this is best effort
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// Without flock, pages are only locked against other saves in this process
+var fileLocks sync.Map
+
+func lockFile(f *os.File) error {
+	mu, _ := fileLocks.LoadOrStore(f.Name(), &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	if mu, ok := fileLocks.Load(f.Name()); ok {
+		mu.(*sync.Mutex).Unlock()
+	}
+	return nil
+}
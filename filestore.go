@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileStore keeps each page as a text file in the data directory, with its
+// revisions as numbered files under .history
+type fileStore struct{}
+
+// Helper to load page files from the data directory, creating it if it doesn't exist
+func getDataFileNames(path string) ([]string, error) {
+	var fileNames []string
+
+	// check that the directory exists, create it if not...
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		err := os.Mkdir(path, os.ModePerm)
+		if err != nil {
+			log.Printf("Directory %s doesn't exist and couldn't create\n", path)
+			return fileNames, err
+		}
+	}
+
+	// walk the directory, descending into namespaces
+	err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		// skip the revision history, attachments and anything else that isn't a page
+		if entry.IsDir() {
+			if rel != "." && (strings.HasPrefix(entry.Name(), ".") || rel == "attachments") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name, ok := strings.CutSuffix(rel, ".txt")
+		if !ok {
+			return nil
+		}
+		if title, ok := titleFromFileName(name); ok {
+			fileNames = append(fileNames, title)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Couldn't read directory %s: %s\n", path, err.Error())
+		return fileNames, err
+	}
+	slices.SortFunc(fileNames, compareTitles)
+	return fileNames, nil
+}
+
+// Build a path inside the data directory
+func dataPath(elem ...string) string {
+	return filepath.Join(append([]string{config.DataDir}, elem...)...)
+}
+
+// Pages in a namespace live in a directory named after it
+func pageFile(title string) string {
+	return dataPath(filepath.FromSlash(titlePath(title)) + ".txt")
+}
+
+// Tidy away a deleted page's namespace directories once they're empty
+func removeEmptyNamespaces(title string) {
+	namespaces := titleNamespaces(title)
+	for i := len(namespaces) - 1; i >= 0; i-- {
+		if os.Remove(dataPath(filepath.FromSlash(titlePath(namespaces[i])))) != nil {
+			return
+		}
+	}
+}
+
+func (fileStore) List() ([]string, error) {
+	return getDataFileNames(config.DataDir)
+}
+
+// Walk the pages a directory at a time, so only one directory's listing is
+// held at once. Only the namespaces the prefix reaches into are read.
+func (fileStore) WalkPages(prefix string, fn func(title string) error) error {
+	ns := ""
+	if i := strings.LastIndex(prefix, namespaceSeparator); i >= 0 {
+		ns = prefix[:i]
+	}
+	return walkNamespace(ns, prefix, fn)
+}
+
+func walkNamespace(ns, prefix string, fn func(title string) error) error {
+	dir := ""
+	if ns != "" {
+		dir = titlePath(ns) + "/"
+	}
+	entries, err := os.ReadDir(dataPath(filepath.FromSlash(dir)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// entries sort by their escaped names, and a namespace's pages after its
+	// own page, so they're put in title order first
+	type entry struct {
+		title string
+		isDir bool
+	}
+	var list []entry
+	for _, e := range entries {
+		if e.IsDir() {
+			if strings.HasPrefix(e.Name(), ".") || (ns == "" && e.Name() == "attachments") {
+				continue
+			}
+			if title, ok := titleFromFileName(dir + e.Name()); ok {
+				list = append(list, entry{title, true})
+			}
+		} else if name, ok := strings.CutSuffix(e.Name(), ".txt"); ok {
+			if title, ok := titleFromFileName(dir + name); ok {
+				list = append(list, entry{title, false})
+			}
+		}
+	}
+	slices.SortFunc(list, func(a, b entry) int {
+		if c := strings.Compare(titleName(a.title), titleName(b.title)); c != 0 {
+			return c
+		}
+		if a.isDir == b.isDir {
+			return 0
+		}
+		if a.isDir {
+			return 1
+		}
+		return -1
+	})
+	for _, e := range list {
+		if !e.isDir {
+			if strings.HasPrefix(e.title, prefix) {
+				if err := fn(e.title); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		inside := e.title + namespaceSeparator
+		if strings.HasPrefix(inside, prefix) || strings.HasPrefix(prefix, inside) {
+			if err := walkNamespace(e.title, prefix, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A namespace is a directory, so listing it only reads that directory
+func (fileStore) ListNamespace(ns string) (pages, namespaces []string, err error) {
+	dir := titlePath(ns)
+	entries, err := os.ReadDir(dataPath(filepath.FromSlash(dir)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if entry.IsDir() {
+			if title, ok := titleFromFileName(dir + namespaceSeparator + entry.Name()); ok {
+				namespaces = append(namespaces, title)
+			}
+		} else if name, ok := strings.CutSuffix(entry.Name(), ".txt"); ok {
+			if title, ok := titleFromFileName(dir + namespaceSeparator + name); ok {
+				pages = append(pages, title)
+			}
+		}
+	}
+	return pages, namespaces, nil
+}
+
+func (fileStore) Exists(title string) bool {
+	_, err := os.Stat(pageFile(title))
+	return err == nil
+}
+
+func (fileStore) Load(ctx context.Context, title string) (*Page, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	filename := pageFile(title)
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &Page{Title: title, Body: body}, nil
+}
+
+func (s fileStore) Save(ctx context.Context, p *Page, edit Edit) (*Revision, error) {
+	unlock, err := lockPage(p.Title)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	// the request may have given up while other saves had the page
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	filename := pageFile(p.Title)
+	if err := seedHistory(p.Title); err != nil {
+		return nil, fmt.Errorf("couldn't record the page's earlier contents: %w", err)
+	}
+	if current, err := os.ReadFile(filename); err == nil && bytes.Equal(current, p.Body) {
+		return nil, errUnchanged
+	}
+	rev := &Revision{Time: time.Now().UTC(), Author: edit.Author, Summary: edit.Summary}
+	if err := saveRevision(p.Title, p.Body, rev); err != nil {
+		return nil, fmt.Errorf("couldn't record the new revision: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(filename), os.ModePerm)
+	if err == nil {
+		err = writeFileAtomic(filename, p.Body)
+	}
+	if err != nil {
+		// the page never had this content, so its history mustn't say it did
+		return nil, errors.Join(err, os.Remove(revisionFile(p.Title, rev.Number)), os.Remove(revisionMetaFile(p.Title, rev.Number)))
+	}
+	return rev, nil
+}
+
+func (fileStore) Delete(ctx context.Context, title string, edit Edit) error {
+	unlock, err := lockPage(title)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.Remove(pageFile(title)); err != nil {
+		return err
+	}
+	removeEmptyNamespaces(title)
+	return nil
+}
+
+func (fileStore) ModTime(title string) (time.Time, error) {
+	info, err := os.Stat(pageFile(title))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func (fileStore) Revisions(title string) ([]Revision, error) {
+	return listRevisions(title)
+}
+
+func (fileStore) LoadRevision(title string, number int) ([]byte, error) {
+	return os.ReadFile(revisionFile(title, number))
+}
+
+func (fileStore) PurgeHistory(title string) error {
+	return os.RemoveAll(revisionDir(title))
+}
+
+func revisionDir(title string) string {
+	return dataPath(".history", titleFileName(title))
+}
+
+func revisionFile(title string, number int) string {
+	return filepath.Join(revisionDir(title), strconv.Itoa(number)+".txt")
+}
+
+func revisionMetaFile(title string, number int) string {
+	return filepath.Join(revisionDir(title), strconv.Itoa(number)+".json")
+}
+
+// Read a revision's metadata, falling back to the content's mtime for revisions saved without any
+func loadRevisionMeta(title string, entry os.DirEntry, number int) (Revision, error) {
+	rev := Revision{Number: number}
+	data, err := os.ReadFile(revisionMetaFile(title, number))
+	if err == nil {
+		err = json.Unmarshal(data, &rev)
+		return rev, err
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return rev, err
+	}
+	info, err := entry.Info()
+	if err != nil {
+		return rev, err
+	}
+	rev.Time = info.ModTime()
+	return rev, nil
+}
+
+// List the revisions of a page, oldest first. Pages without history have no revisions.
+func listRevisions(title string) ([]Revision, error) {
+	entries, err := os.ReadDir(revisionDir(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var revs []Revision
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".txt")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		number, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		rev, err := loadRevisionMeta(title, entry, number)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Number < revs[j].Number })
+	return revs, nil
+}
+
+// Record body as the next numbered revision of a page, filling in rev's number
+func saveRevision(title string, body []byte, rev *Revision) error {
+	revs, err := listRevisions(title)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(revisionDir(title), os.ModePerm); err != nil {
+		return err
+	}
+	rev.Number = 1
+	if len(revs) > 0 {
+		rev.Number = revs[len(revs)-1].Number + 1
+	}
+	meta, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(revisionMetaFile(title, rev.Number), meta); err != nil {
+		return err
+	}
+	return writeFileAtomic(revisionFile(title, rev.Number), body)
+}
+
+// Pages saved before revisions existed get their current content recorded
+// as the first revision, so the next save doesn't lose it
+func seedHistory(title string) error {
+	revs, err := listRevisions(title)
+	if err != nil || len(revs) > 0 {
+		return err
+	}
+	info, err := os.Stat(pageFile(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	body, err := os.ReadFile(pageFile(title))
+	if err != nil {
+		return err
+	}
+	return saveRevision(title, body, &Revision{Time: info.ModTime()})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+var (
+	// inline code stays on one line; ``` fences are set aside before it's looked for
+	inlineCode = regexp.MustCompile("`([^`\n]+)`")
+	// emphasis can't start or end with a space, so 2 * 3 * 4 is left alone
+	boldText   = regexp.MustCompile(`\*\*([^\s*](?:[^*\n]*[^\s*])?)\*\*`)
+	italicText = regexp.MustCompile(`(?m)(^|[^\w*])\*([^\s*](?:[^*\n]*[^\s*])?)\*`)
+	// - item, * item or 1. item
+	listItem = regexp.MustCompile(`^[ \t]*(?:([-*])|[0-9]+\.)[ \t]+(.*)$`)
+)
+
+// Set `code` aside, shown as it was typed
+func extractInlineCode(escaped []byte, held *placeholders) []byte {
+	return inlineCode.ReplaceAllFunc(escaped, func(m []byte) []byte {
+		return held.mark(`<code>` + string(inlineCode.FindSubmatch(m)[1]) + `</code>`)
+	})
+}
+
+// Turn **bold** and *italic* into HTML. Escaping leaves * alone.
+func renderEmphasis(escaped []byte) []byte {
+	out := boldText.ReplaceAll(escaped, []byte(`<strong>$1</strong>`))
+	return italicText.ReplaceAll(out, []byte(`$1<em>$2</em>`))
+}
+
+// Turn runs of lines starting with - or * into bulleted lists, and with 1.
+// into numbered ones
+func renderLists(escaped []byte) []byte {
+	lines := bytes.Split(escaped, []byte("\n"))
+	var out [][]byte
+	for i := 0; i < len(lines); {
+		m := listItem.FindSubmatch(lines[i])
+		if m == nil {
+			out = append(out, lines[i])
+			i++
+			continue
+		}
+		bulleted := len(m[1]) > 0
+		tag := "ol"
+		if bulleted {
+			tag = "ul"
+		}
+		var b bytes.Buffer
+		b.WriteString("<" + tag + ">")
+		for ; i < len(lines); i++ {
+			m = listItem.FindSubmatch(lines[i])
+			if m == nil || (len(m[1]) > 0) != bulleted {
+				break
+			}
+			b.WriteString("<li>")
+			b.Write(bytes.TrimRight(m[2], "\r"))
+			b.WriteString("</li>")
+		}
+		b.WriteString("</" + tag + ">")
+		out = append(out, b.Bytes())
+	}
+	return bytes.Join(out, []byte("\n"))
+}
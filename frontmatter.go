@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/text/language"
+	"gopkg.in/yaml.v3"
+)
+
+// Front matter opens a page between --- lines for YAML, or +++ lines for TOML:
+//
+//	---
+//	title: Release checklist
+//	tags: [process, releases]
+//	language: de
+//	---
+var frontMatterFences = map[string]string{"---": "yaml", "+++": "toml"}
+
+// PageMeta is what a page's front matter says about it. Saving a page that
+// has front matter keeps Updated current, and fills in Created and Author
+// the first time. Keys gowiki doesn't know are kept as they are.
+type PageMeta struct {
+	Title    string     `json:"title,omitempty"`
+	Tags     []string   `json:"tags,omitempty"`
+	Language string     `json:"language,omitempty"`
+	Author   string     `json:"author,omitempty"`
+	Created  *time.Time `json:"created,omitempty"`
+	Updated  *time.Time `json:"updated,omitempty"`
+	format   string
+	extra    map[string]any
+}
+
+// Split a page body into its front matter and the content after it. The
+// front matter is empty if the page has none.
+func splitFrontMatter(body []byte) (format string, front, content []byte) {
+	line, rest, ok := bytes.Cut(body, []byte("\n"))
+	if !ok {
+		return "", nil, body
+	}
+	fence := string(bytes.TrimRight(line, " \t\r"))
+	format, ok = frontMatterFences[fence]
+	if !ok {
+		return "", nil, body
+	}
+	for i := 0; i < len(rest); {
+		end := bytes.IndexByte(rest[i:], '\n')
+		next := len(rest)
+		if end >= 0 {
+			next = i + end + 1
+		}
+		if string(bytes.TrimRight(rest[i:next], " \t\r\n")) == fence {
+			return format, rest[:i], rest[next:]
+		}
+		i = next
+	}
+	// never closed, so it isn't front matter after all
+	return "", nil, body
+}
+
+// Parse a page's front matter. Pages without any have empty metadata; it's
+// an error if the front matter is there but doesn't parse.
+func parseFrontMatter(body []byte) (PageMeta, []byte, error) {
+	format, front, content := splitFrontMatter(body)
+	if format == "" {
+		return PageMeta{}, body, nil
+	}
+	fields := make(map[string]any)
+	var err error
+	if format == "yaml" {
+		err = yaml.Unmarshal(front, &fields)
+	} else {
+		_, err = toml.Decode(string(front), &fields)
+	}
+	if err != nil {
+		return PageMeta{}, body, fmt.Errorf("the front matter isn't valid %s: %w", strings.ToUpper(format), err)
+	}
+	meta := PageMeta{format: format, extra: fields}
+	meta.Title = metaString(fields, "title")
+	meta.Language = metaString(fields, "language")
+	meta.Author = metaString(fields, "author")
+	meta.Created = metaTime(fields, "created")
+	meta.Updated = metaTime(fields, "updated")
+	switch tags := fields["tags"].(type) {
+	case string:
+		meta.Tags = strings.Fields(strings.ReplaceAll(tags, ",", " "))
+		delete(fields, "tags")
+	case []any:
+		for _, tag := range tags {
+			if s, ok := tag.(string); ok {
+				meta.Tags = append(meta.Tags, s)
+			}
+		}
+		delete(fields, "tags")
+	}
+	return meta, content, nil
+}
+
+func metaString(fields map[string]any, key string) string {
+	if s, ok := fields[key].(string); ok {
+		delete(fields, key)
+		return s
+	}
+	return ""
+}
+
+func metaTime(fields map[string]any, key string) *time.Time {
+	if t, ok := fields[key].(time.Time); ok {
+		delete(fields, key)
+		return &t
+	}
+	return nil
+}
+
+// Write the front matter back out, in the format it came in
+func (m PageMeta) marshal() ([]byte, error) {
+	fields := make(map[string]any, len(m.extra)+6)
+	for k, v := range m.extra {
+		fields[k] = v
+	}
+	if m.Title != "" {
+		fields["title"] = m.Title
+	}
+	if len(m.Tags) > 0 {
+		fields["tags"] = m.Tags
+	}
+	if m.Language != "" {
+		fields["language"] = m.Language
+	}
+	if m.Author != "" {
+		fields["author"] = m.Author
+	}
+	if m.Created != nil {
+		fields["created"] = *m.Created
+	}
+	if m.Updated != nil {
+		fields["updated"] = *m.Updated
+	}
+	var buf bytes.Buffer
+	fence := "---"
+	if m.format == "toml" {
+		fence = "+++"
+	}
+	buf.WriteString(fence + "\n")
+	if m.format == "toml" {
+		if err := toml.NewEncoder(&buf).Encode(fields); err != nil {
+			return nil, err
+		}
+	} else {
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(fields); err != nil {
+			return nil, err
+		}
+		enc.Close()
+	}
+	buf.WriteString(fence + "\n")
+	return buf.Bytes(), nil
+}
+
+// Stamp a page's front matter for a save. Pages without front matter, or
+// whose front matter doesn't parse, are left alone.
+func stampFrontMatter(p *Page, author string, now time.Time) error {
+	meta, content, err := parseFrontMatter(p.Body)
+	if err != nil || meta.format == "" {
+		p.Meta = meta
+		return nil
+	}
+	now = now.UTC().Truncate(time.Second)
+	if meta.Created == nil {
+		meta.Created = &now
+	}
+	if meta.Author == "" {
+		meta.Author = author
+	}
+	meta.Updated = &now
+	front, err := meta.marshal()
+	if err != nil {
+		return err
+	}
+	p.Body = append(front, content...)
+	p.Meta = meta
+	return nil
+}
+
+// DisplayTitle is the title the front matter gives the page, if any, for headings
+func (p *Page) DisplayTitle() string {
+	if p.Meta.Title != "" {
+		return p.Meta.Title
+	}
+	return p.Title
+}
+
+// The language the front matter says the page is written in, as a tag such
+// as de or ar, or empty if it doesn't say or what it says isn't a language
+func (p *Page) Language() string {
+	if p.Meta.Language == "" {
+		return ""
+	}
+	tag, err := language.Parse(p.Meta.Language)
+	if err != nil {
+		return ""
+	}
+	return tag.String()
+}
+
+// Which way the page's text runs, for its dir attribute: rtl for pages in
+// Arabic, Hebrew and the like, else ltr
+func (p *Page) Direction() string {
+	return textDirection(p.Language())
+}
+
+// The front matter's tags, sorted, for listing on the page
+func (p *Page) MetaTags() []string {
+	var found []string
+	for _, tag := range p.Meta.Tags {
+		if tag, ok := normalizeTag(tag); ok {
+			found = append(found, tag)
+		}
+	}
+	sort.Strings(found)
+	return slices.Compact(found)
+}
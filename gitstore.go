@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Author address for anonymous edits, so they can be told apart from a user called "anonymous"
+const gitAnonymousEmail = "anonymous@gowiki.invalid"
+
+// Only the pages themselves are versioned; everything else in the data directory stays out of the repository
+const gitIgnore = "/.*\n!/.gitignore\n/attachments/\n"
+
+var gitHunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// gitStore keeps the data directory as a Git repository with a commit for
+// every save, so history can be browsed, backed up and pushed with plain git.
+// Pages are read straight from the working tree like the file store.
+type gitStore struct {
+	fileStore
+	dir string
+	mu  sync.Mutex
+}
+
+// A commit that touched a page
+type gitCommit struct {
+	hash string
+	rev  Revision
+}
+
+func openGitStore(dir string) (*gitStore, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, errors.New("git storage needs the git command installed")
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	g := &gitStore{dir: dir}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); errors.Is(err, os.ErrNotExist) {
+		if _, err := g.git("init", "-q"); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte(gitIgnore), 0644); err != nil {
+			return nil, err
+		}
+		if _, err := g.git("add", "--", ".gitignore"); err != nil {
+			return nil, err
+		}
+	}
+
+	// pick up pages written before the repository existed, or edited behind our back
+	titles, err := g.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, title := range titles {
+		if _, err := g.git("add", "--", g.path(title)); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := g.git("diff", "--cached", "--quiet"); err != nil {
+		if _, err := g.commit(Edit{}, "Import existing pages"); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// Run git in the repository, returning its output or an error including what it printed
+func (g *gitStore) git(args ...string) ([]byte, error) {
+	return g.gitEnv(nil, args...)
+}
+
+func (g *gitStore) gitEnv(env []string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", g.dir, "-c", "commit.gpgsign=false", "-c", "core.quotepath=off"}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// The page's file name relative to the repository
+func (g *gitStore) path(title string) string {
+	return titlePath(title) + ".txt"
+}
+
+// Commit whatever is staged, crediting the edit's author. The summary is the
+// commit message, falling back to fallback when the edit has none.
+func (g *gitStore) commit(edit Edit, fallback string) (time.Time, error) {
+	name, email := edit.Author, edit.Author+"@gowiki.invalid"
+	if edit.Author == "" {
+		name, email = "anonymous", gitAnonymousEmail
+	}
+	message := edit.Summary
+	if message == "" {
+		message = fallback
+	}
+	now := time.Now().UTC()
+	_, err := g.gitEnv([]string{
+		"GIT_AUTHOR_NAME=" + name,
+		"GIT_AUTHOR_EMAIL=" + email,
+		"GIT_COMMITTER_NAME=gowiki",
+		"GIT_COMMITTER_EMAIL=gowiki@gowiki.invalid",
+	}, "commit", "-q", "--allow-empty", "-m", message)
+	return now, err
+}
+
+// The commits that changed a page, oldest first, leaving out the ones deleting it
+func (g *gitStore) commits(title string) ([]gitCommit, error) {
+	out, err := g.git("log", "--diff-filter=AM", "--format=%H%x1f%an%x1f%ae%x1f%at%x1f%s", "--", g.path(title))
+	if err != nil {
+		// a fresh repository has no HEAD yet
+		return nil, nil
+	}
+	var commits []gitCommit
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) != 5 {
+			continue
+		}
+		stamp, _ := strconv.ParseInt(fields[3], 10, 64)
+		rev := Revision{Time: time.Unix(stamp, 0).UTC(), Author: fields[1], Summary: fields[4]}
+		if fields[2] == gitAnonymousEmail {
+			rev.Author = ""
+		}
+		commits = append(commits, gitCommit{hash: fields[0], rev: rev})
+	}
+	// git lists newest first; revisions count up from the oldest
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+	for i := range commits {
+		commits[i].rev.Number = i + 1
+	}
+	return commits, nil
+}
+
+func (g *gitStore) commitFor(title string, number int) (string, error) {
+	commits, err := g.commits(title)
+	if err != nil {
+		return "", err
+	}
+	if number < 1 || number > len(commits) {
+		return "", os.ErrNotExist
+	}
+	return commits[number-1].hash, nil
+}
+
+// Once git is running it's left to finish, as stopping it part way could
+// leave the repository locked; a request that has given up while waiting its
+// turn doesn't start it. The page is locked as the file store locks it, so
+// other wiki processes sharing the repository wait too.
+func (g *gitStore) Save(ctx context.Context, p *Page, edit Edit) (*Revision, error) {
+	unlock, err := lockPage(p.Title)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(pageFile(p.Title)), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(pageFile(p.Title), p.Body); err != nil {
+		return nil, err
+	}
+	if _, err := g.git("add", "--", g.path(p.Title)); err != nil {
+		return nil, err
+	}
+	// saving identical content leaves nothing to commit, and so no new revision
+	if _, err := g.git("diff", "--cached", "--quiet", "--", g.path(p.Title)); err == nil {
+		return nil, errUnchanged
+	}
+	when, err := g.commit(edit, "Update "+p.Title)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := g.commits(p.Title)
+	if err != nil {
+		return nil, err
+	}
+	return &Revision{Number: len(commits), Time: when, Author: edit.Author, Summary: edit.Summary}, nil
+}
+
+func (g *gitStore) Delete(ctx context.Context, title string, edit Edit) error {
+	unlock, err := lockPage(title)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !g.Exists(title) {
+		return os.ErrNotExist
+	}
+	if _, err := g.git("rm", "-q", "--", g.path(title)); err != nil {
+		return err
+	}
+	_, err = g.commit(edit, "Delete "+title)
+	return err
+}
+
+// A page's commits stay in the repository: taking them out would rewrite
+// every commit since, and any copy that's been pushed or cloned keeps them
+// anyway. This takes the place of the file store's PurgeHistory, which would
+// only remove a .history directory git doesn't use.
+func (g *gitStore) PurgeHistory(title string) error {
+	return fmt.Errorf("git keeps the history of %s: %w", title, errors.ErrUnsupported)
+}
+
+func (g *gitStore) Revisions(title string) ([]Revision, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	commits, err := g.commits(title)
+	if err != nil {
+		return nil, err
+	}
+	revs := make([]Revision, len(commits))
+	for i, c := range commits {
+		revs[i] = c.rev
+	}
+	return revs, nil
+}
+
+func (g *gitStore) LoadRevision(title string, number int) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	hash, err := g.commitFor(title, number)
+	if err != nil {
+		return nil, err
+	}
+	return g.git("show", hash+":"+g.path(title))
+}
+
+// Diff asks git diff for the changes and parses its unified output into hunks
+func (g *gitStore) Diff(title string, from, to int) ([]diffHunk, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fromHash, err := g.commitFor(title, from)
+	if err != nil {
+		return nil, err
+	}
+	toHash, err := g.commitFor(title, to)
+	if err != nil {
+		return nil, err
+	}
+	out, err := g.git("diff", "--no-color", "-U3", fromHash, toHash, "--", g.path(title))
+	if err != nil {
+		return nil, err
+	}
+	return parseGitDiff(out), nil
+}
+
+func parseGitDiff(out []byte) []diffHunk {
+	var hunks []diffHunk
+	for _, line := range strings.Split(strings.ReplaceAll(string(out), "\r\n", "\n"), "\n") {
+		if m := gitHunkHeader.FindStringSubmatch(line); m != nil {
+			h := diffHunk{FromCount: 1, ToCount: 1}
+			h.FromLine, _ = strconv.Atoi(m[1])
+			h.ToLine, _ = strconv.Atoi(m[3])
+			if m[2] != "" {
+				h.FromCount, _ = strconv.Atoi(m[2])
+			}
+			if m[4] != "" {
+				h.ToCount, _ = strconv.Atoi(m[4])
+			}
+			hunks = append(hunks, h)
+			continue
+		}
+		// skip the file headers before the first hunk, and markers like "\ No newline at end of file"
+		if len(hunks) == 0 || line == "" {
+			continue
+		}
+		h := &hunks[len(hunks)-1]
+		switch line[0] {
+		case ' ':
+			h.Lines = append(h.Lines, diffLine{diffContext, line[1:]})
+		case '-':
+			h.Lines = append(h.Lines, diffLine{diffDelete, line[1:]})
+		case '+':
+			h.Lines = append(h.Lines, diffLine{diffInsert, line[1:]})
+		}
+	}
+	return hunks
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// /healthz answers as long as the server is up, for liveness probes
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+// /readyz checks the wiki can do its job, for load balancers and readiness
+// probes: pages can be written and the templates are loaded. It answers 503
+// with what's wrong if not.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	var problems []string
+	if err := checkWritable(); err != nil {
+		problems = append(problems, "data directory: "+err.Error())
+	}
+	if t, err := currentTemplates(locales[config.Language], siteZone); err != nil {
+		problems = append(problems, "templates: "+err.Error())
+	} else if t == nil || t.Lookup("view.html") == nil {
+		problems = append(problems, "templates: not loaded")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(strings.Join(problems, "\n") + "\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// Can a file be created in the data directory?
+func checkWritable() error {
+	f, err := os.CreateTemp(config.DataDir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
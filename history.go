@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Revision describes one saved version of a page
+type Revision struct {
+	Number  int       `json:"-"`
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author,omitempty"`
+	Summary string    `json:"summary,omitempty"`
+}
+
+var (
+	diffPath    = regexp.MustCompile("^/diff/(.+)/([0-9]+)/([0-9]+)$")
+	restorePath = regexp.MustCompile("^/restore/(.+)/([0-9]+)$")
+)
+
+// A row on the history page, with the neighbouring revisions to diff against
+type historyEntry struct {
+	Revision
+	Previous int
+	Latest   int
+}
+
+func historyHandler(w http.ResponseWriter, r *http.Request, title string) {
+	revs, err := store.Revisions(title)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if len(revs) == 0 {
+		notFound(w, r)
+		return
+	}
+
+	// newest first
+	latest := revs[len(revs)-1].Number
+	entries := make([]historyEntry, 0, len(revs))
+	for i := len(revs) - 1; i >= 0; i-- {
+		entry := historyEntry{Revision: revs[i], Latest: latest}
+		if i > 0 {
+			entry.Previous = revs[i-1].Number
+		}
+		entries = append(entries, entry)
+	}
+	renderTemplate(w, r, "history", struct {
+		Title     string
+		Revisions []historyEntry
+	}{title, entries})
+}
+
+// Diff two revisions of a page, letting the store do it when it knows how
+func revisionDiff(title string, from, to int) ([]diffHunk, error) {
+	if d, ok := store.(differ); ok {
+		return d.Diff(title, from, to)
+	}
+	a, err := store.LoadRevision(title, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := store.LoadRevision(title, to)
+	if err != nil {
+		return nil, err
+	}
+	return unifiedDiff(splitLines(a), splitLines(b), 3), nil
+}
+
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	m := diffPath.FindStringSubmatch(r.URL.Path)
+	if m == nil || !validTitle(m[1]) {
+		notFound(w, r)
+		return
+	}
+	title := m[1]
+	if !checkPermission(w, r, title, permRead) {
+		return
+	}
+	from, _ := strconv.Atoi(m[2])
+	to, _ := strconv.Atoi(m[3])
+
+	hunks, err := revisionDiff(title, from, to)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	renderTemplate(w, r, "diff", struct {
+		Title    string
+		From, To int
+		Hunks    []diffHunk
+	}{title, from, to, hunks})
+}
+
+// Restoring saves an old revision's content as a new revision, so it can itself be undone
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "That page can only be reached by submitting a form.")
+		return
+	}
+	m := restorePath.FindStringSubmatch(r.URL.Path)
+	if m == nil || !validTitle(m[1]) {
+		notFound(w, r)
+		return
+	}
+	title := m[1]
+	if !checkPermission(w, r, title, permWrite) {
+		return
+	}
+	number, _ := strconv.Atoi(m[2])
+
+	body, err := store.LoadRevision(title, number)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	p := &Page{Title: title, Body: body}
+	edit := newEdit(r, "Restored revision "+strconv.Itoa(number))
+	if err := p.save(r.Context(), edit); errors.Is(err, errPageTooLarge) {
+		pageTooLarge(w, r)
+		return
+	} else if err != nil {
+		var notSaved *notSavedError
+		if !errors.As(err, &notSaved) {
+			serverError(w, r, err)
+			return
+		}
+		// as with a save, hand the old revision over in the editor to try again
+		log.Printf("Couldn't restore %s to revision %d: %s\n", title, number, notSaved.err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		renderEditor(w, r, editData{Page: &Page{Title: title, Body: body}, Summary: edit.Summary,
+			System: systemPage(title), SaveError: notSaved.err.Error()})
+		return
+	}
+	audit(r, "revert", title, "to revision "+strconv.Itoa(number))
+	http.Redirect(w, r, pageURL("view", title), http.StatusFound)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// The templates are written in English, with each bit of text wrapped in t,
+// e.g. {{t "Edit"}} or {{t "Account: %s" .User.Username}}. A catalog in
+// templates/locales maps that English to another language, one JSON file per
+// locale named by its language tag; anything a catalog leaves out stays in
+// English, so a half-done translation still works.
+
+// The language the templates are written in, which needs no catalog
+const sourceLocale = "en"
+
+// A visitor's chosen language is kept in this cookie for a year, like their theme
+const (
+	languageCookie   = "lang"
+	languageLifetime = 365 * 24 * time.Hour
+)
+
+type locale struct {
+	Code     string            `json:"-"`
+	Name     string            `json:"name"` // what the language calls itself, for the picker
+	Messages map[string]string `json:"messages"`
+}
+
+// The templates' t function: the message in this locale, with any arguments
+// filled in as fmt.Sprintf does
+func (l *locale) translate(msg string, args ...any) string {
+	if translated := l.Messages[msg]; translated != "" {
+		msg = translated
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// The template functions that differ from one locale to the next
+func (l *locale) funcs() template.FuncMap {
+	return template.FuncMap{
+		"t":    l.translate,
+		"lang": func() string { return l.Code },
+		"dir":  func() string { return textDirection(l.Code) },
+	}
+}
+
+// The scripts written right to left
+var rtlScripts = map[string]bool{
+	"Adlm": true, "Arab": true, "Hebr": true, "Mand": true, "Nkoo": true,
+	"Rohg": true, "Samr": true, "Syrc": true, "Thaa": true,
+}
+
+// Which way a language runs, ltr or rtl, going by the script it's written
+// in: ar, fa and he run right to left, as does az-Arab, but az doesn't
+func textDirection(code string) string {
+	tag, err := language.Parse(code)
+	if err != nil {
+		return "ltr"
+	}
+	if script, _ := tag.Script(); rtlScripts[script.String()] {
+		return "rtl"
+	}
+	return "ltr"
+}
+
+var (
+	locales map[string]*locale
+	// English first, then the catalogs by name; the matcher's tags are in the same order
+	localeList    []*locale
+	localeMatcher language.Matcher
+)
+
+// The templates' locales function, for language pickers
+func listLocales() []*locale {
+	return localeList
+}
+
+func readLocale(fsys fs.FS, code string) (*locale, error) {
+	if code == sourceLocale {
+		return &locale{Code: sourceLocale, Name: "English"}, nil
+	}
+	data, err := fs.ReadFile(fsys, path.Join("locales", code+".json"))
+	if err != nil {
+		return nil, err
+	}
+	l := &locale{Code: code}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("locales/%s.json: %w", code, err)
+	}
+	if l.Name == "" {
+		l.Name = code
+	}
+	return l, nil
+}
+
+// Read the catalogs in templates/locales, such as de.json or pt-BR.json
+func loadLocales() error {
+	fsys := templateFS()
+	list := []*locale{{Code: sourceLocale, Name: "English"}}
+	entries, err := fs.ReadDir(fsys, "locales")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, entry := range entries {
+		code, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() || code == sourceLocale {
+			continue
+		}
+		if _, err := language.Parse(code); err != nil {
+			return fmt.Errorf("locales/%s should be named after a language tag, such as de.json or pt-BR.json", entry.Name())
+		}
+		l, err := readLocale(fsys, code)
+		if err != nil {
+			return err
+		}
+		list = append(list, l)
+	}
+	byCode := make(map[string]*locale, len(list))
+	tags := make([]language.Tag, len(list))
+	for i, l := range list {
+		byCode[l.Code] = l
+		tags[i] = language.Make(l.Code)
+	}
+	if _, ok := byCode[config.Language]; !ok {
+		return fmt.Errorf("there's no catalog for -language %s in templates/locales", config.Language)
+	}
+	locales, localeList, localeMatcher = byCode, list, language.NewMatcher(tags)
+	return nil
+}
+
+// The locale for a request: the language the user picked for their account,
+// else the one picked in this browser, else the closest to what the browser
+// asks for in Accept-Language, else the site's
+func requestLocale(r *http.Request) *locale {
+	if user := currentUser(r); user != nil {
+		if l, ok := locales[user.Language]; ok {
+			return l
+		}
+	}
+	if c, err := r.Cookie(languageCookie); err == nil {
+		if l, ok := locales[c.Value]; ok {
+			return l
+		}
+	}
+	if tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language")); err == nil && len(tags) > 0 {
+		if _, i, confidence := localeMatcher.Match(tags...); confidence > language.No {
+			return localeList[i]
+		}
+	}
+	return locales[config.Language]
+}
+
+// The locale for mail to a user, who isn't there to ask: theirs if they've
+// picked one, else the site's
+func userLocale(u *User) *locale {
+	if l, ok := locales[u.Language]; ok {
+		return l
+	}
+	return locales[config.Language]
+}
+
+// POST picks a language for this browser, and for the account if someone's
+// logged in, going back to where the visitor came from
+func languageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "That page can only be reached by submitting a form.")
+		return
+	}
+	code := r.FormValue("lang")
+	if _, ok := locales[code]; !ok {
+		httpError(w, r, http.StatusBadRequest, "There's no translation for "+code+".")
+		return
+	}
+	if user := currentUser(r); user != nil {
+		if err := users.update(user.Username, func(u *User) { u.Language = code }); err != nil {
+			serverError(w, r, err)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     languageCookie,
+		Value:    code,
+		Path:     cookiePath(),
+		Expires:  time.Now().Add(languageLifetime),
+		SameSite: http.SameSiteLaxMode,
+		Secure:   isHTTPS(r),
+	})
+	back := r.Referer()
+	if !strings.HasPrefix(back, absoluteURL(r, sitePath("/"))) {
+		back = sitePath("/")
+	}
+	http.Redirect(w, r, back, http.StatusFound)
+}
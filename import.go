@@ -0,0 +1,130 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// What importing an archive entry does, or would do in a dry run
+type importResult struct {
+	File   string
+	Title  string
+	Action string // create, overwrite or skip
+	Reason string // why an entry was skipped
+}
+
+// How each action reads once it's been done
+var importDone = map[string]string{"create": "created", "overwrite": "overwritten"}
+
+// GET shows the import form, POST reads an uploaded zip of .txt or .md files,
+// one page each, named after the page's title. A dry run reports what would
+// happen without saving anything.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Results []importResult
+		DryRun  bool
+		Error   string
+		MaxMB   int64
+	}{MaxMB: config.MaxUploadBytes >> 20}
+	if r.Method == http.MethodPost {
+		data.DryRun = r.FormValue("dry_run") != ""
+		results, err := receiveImport(w, r, data.DryRun)
+		if err != nil {
+			data.Error = err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		if !data.DryRun {
+			for _, result := range results {
+				if result.Action != "skip" {
+					audit(r, "import", result.Title, importDone[result.Action]+" from "+result.File)
+				}
+			}
+		}
+		data.Results = results
+	}
+	renderTemplate(w, r, "import", data)
+}
+
+func receiveImport(w http.ResponseWriter, r *http.Request, dryRun bool) ([]importResult, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxUploadBytes+1<<20)
+	file, header, err := r.FormFile("archive")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || err == nil && header.Size > config.MaxUploadBytes {
+		return nil, fmt.Errorf("archives are limited to %d MB", config.MaxUploadBytes>>20)
+	}
+	if err != nil {
+		return nil, errors.New("choose an archive to import")
+	}
+	defer file.Close()
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		return nil, errors.New("that doesn't look like a zip archive")
+	}
+	return importArchive(r.Context(), archive, newEdit(r, "Imported from "+path.Base(header.Filename)), dryRun)
+}
+
+// Import each page in the archive, saving a revision for every page that changes
+func importArchive(ctx context.Context, archive *zip.Reader, edit Edit, dryRun bool) ([]importResult, error) {
+	var results []importResult
+	seen := map[string]bool{}
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		result := importResult{File: f.Name, Action: "skip"}
+		ext := strings.ToLower(path.Ext(f.Name))
+		// folders in the archive become namespaces
+		title, ok := titleFromFileName(strings.TrimSuffix(f.Name, path.Ext(f.Name)))
+		switch {
+		case strings.HasPrefix(f.Name, "attachments/"):
+			// exports carry attachments here, but only pages are imported
+			result.Reason = "attachments aren't imported"
+		case ext != ".txt" && ext != ".md":
+			result.Reason = "not a .txt or .md file"
+		case !ok:
+			result.Reason = "the file name isn't a valid page title"
+		case seen[title]:
+			result.Title = title
+			result.Reason = "another file in the archive has the same title"
+		case f.UncompressedSize64 > uint64(config.MaxUploadBytes):
+			result.Title = title
+			result.Reason = "the file is too large"
+		default:
+			result.Title = title
+			result.Action = "create"
+			if pageExists(title) {
+				result.Action = "overwrite"
+			}
+			seen[title] = true
+		}
+		if result.Action != "skip" && !dryRun {
+			if err := importPage(ctx, f, title, edit); err != nil {
+				return results, fmt.Errorf("importing %s: %w", f.Name, err)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func importPage(ctx context.Context, f *zip.File, title string, edit Edit) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	// the size in the header is only a claim, so don't read past the limit regardless
+	var body bytes.Buffer
+	if _, err := io.Copy(&body, io.LimitReader(rc, config.MaxUploadBytes)); err != nil {
+		return err
+	}
+	p := &Page{Title: title, Body: body.Bytes()}
+	return p.save(ctx, edit)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// How many levels deep includes are followed, counting pages included by included pages
+const maxIncludeDepth = 3
+
+var includeLink = regexp.MustCompile(`\{\{include:([^{}]+)\}\}`)
+
+// includeGraph records which pages each page includes, so a change to a page
+// can reach the renderings of every page it ends up in, however indirectly
+type includeGraph struct {
+	mu       sync.RWMutex
+	includes map[string][]string
+}
+
+var includes = &includeGraph{includes: make(map[string][]string)}
+
+func (g *includeGraph) update(title string, body []byte) {
+	var targets []string
+	for _, m := range includeLink.FindAllSubmatch(body, -1) {
+		if target := strings.TrimSpace(string(m[1])); validTitle(target) && !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(targets) == 0 {
+		delete(g.includes, title)
+	} else {
+		g.includes[title] = targets
+	}
+}
+
+func (g *includeGraph) remove(title string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.includes, title)
+}
+
+// The pages showing title through includes, as deep as includes are followed
+func (g *includeGraph) includedBy(title string) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	found := map[string]bool{title: true}
+	var pages []string
+	level := []string{title}
+	for depth := 0; depth <= maxIncludeDepth && len(level) > 0; depth++ {
+		var next []string
+		for source, targets := range g.includes {
+			if found[source] {
+				continue
+			}
+			if slices.ContainsFunc(targets, func(t string) bool { return slices.Contains(level, t) }) {
+				found[source] = true
+				pages = append(pages, source)
+				next = append(next, source)
+			}
+		}
+		level = next
+	}
+	return pages
+}
+
+// Drop the renderings a page appears in: its own, those linking to it, which
+// show whether it exists, and those including it
+func invalidateRenders(title string) {
+	titles := append(append(links.backlinks(title), includes.includedBy(title)...), title)
+	renders.invalidate(titles...)
+	if slices.Contains(titles, config.Sidebar) {
+		sidebar.invalidate()
+	}
+}
+
+// Can a page be included anywhere? Renderings are shared by everyone, so
+// only pages anyone may read can be, or a restricted page could show up
+// for people not allowed to see it.
+func includable(title string) bool {
+	acl, err := loadACL(title)
+	return err == nil && (len(acl.Read) == 0 || slices.Contains(acl.Read, everyone))
+}
+
+// Replace each {{include:PageName}} in rendered HTML with that page's content.
+// including is the chain of pages being rendered, outermost first, for spotting cycles.
+func renderIncludes(l *locale, out []byte, including []string) []byte {
+	return includeLink.ReplaceAllFunc(out, func(directive []byte) []byte {
+		target := strings.TrimSpace(string(includeLink.FindSubmatch(directive)[1]))
+		if !validTitle(target) {
+			return directive
+		}
+		link := `<a class="wikilink" href="` + pageURL("view", target) + `">` + target + `</a>`
+		switch {
+		case slices.Contains(including, target):
+			return includeNotice(l, "%s is already being included, so including it again would go round in a circle", link)
+		case len(including) > maxIncludeDepth:
+			return includeNotice(l, "%s is nested too deeply to be included here", link)
+		case !includable(target):
+			return includeNotice(l, "%s is restricted, so it can't be included", link)
+		}
+		p, err := loadPage(context.Background(), target)
+		if err != nil {
+			return includeNotice(l, "%s doesn't exist yet", `<a class="wikilink missing" href="`+pageURL("edit", target)+`">`+target+`</a>`)
+		}
+		return []byte(`<div class="include">` + string(renderIncluded(l, target, p.Body, including)) + `</div>`)
+	})
+}
+
+// Say why a page wasn't included, in the locale's words, around the link to it
+func includeNotice(l *locale, msg, link string) []byte {
+	return []byte(`<span class="include-error">` + strings.Replace(template.HTMLEscapeString(l.translate(msg)), "%s", link, 1) + `</span>`)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Keep the in-memory indexes in step with a page's new content. Pages
+// linking to it are rendered afresh, since the page may have just come into
+// being, and so are the pages including it.
+func indexPage(title string, body []byte) {
+	indexer.mu.Lock()
+	defer indexer.mu.Unlock()
+	indexer.touch(title)
+	updateIndexes(title, body)
+}
+
+func updateIndexes(title string, body []byte) {
+	// first, so links written in another case count as links here
+	titleCases.add(title)
+	invalidateRenders(title)
+	links.update(title, body)
+	tags.update(title, body)
+	redirects.update(title, body)
+	includes.update(title, body)
+	words.update(title, body)
+}
+
+// Drop a page that no longer exists from the indexes, and the renderings
+// that link to it as an existing page
+func unindexPage(title string) {
+	indexer.mu.Lock()
+	defer indexer.mu.Unlock()
+	indexer.touch(title)
+	removeFromIndexes(title)
+}
+
+func removeFromIndexes(title string) {
+	invalidateRenders(title)
+	links.remove(title)
+	tags.remove(title)
+	redirects.remove(title)
+	includes.remove(title)
+	words.remove(title)
+	lastEdits.remove(title)
+	titleCases.remove(title)
+}
+
+// How the pass over every page that builds the indexes is going, for /admin/status
+type indexStatus struct {
+	Running  bool
+	Started  time.Time
+	Finished time.Time
+	Done     int
+	Total    int
+	Error    string
+}
+
+func (s indexStatus) Took() time.Duration {
+	return s.Finished.Sub(s.Started).Round(time.Millisecond)
+}
+
+// indexBuilder runs the pass over every page. The server doesn't wait for it:
+// until it's done the indexes are missing the pages it hasn't reached, and
+// pages saved meanwhile are indexed as usual and left alone by the pass, so
+// it can't put back what they used to say.
+type indexBuilder struct {
+	// held while one page is indexed, so a save and the pass take turns
+	mu      sync.Mutex
+	status  indexStatus
+	touched map[string]bool // saved or deleted since the pass began
+}
+
+var indexer = &indexBuilder{}
+
+// Note a page changing while the pass runs; callers must hold the lock
+func (b *indexBuilder) touch(title string) {
+	if b.touched != nil {
+		b.touched[title] = true
+	}
+}
+
+func (b *indexBuilder) progress() indexStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
+
+// Start a pass in the background, unless one is already running
+func (b *indexBuilder) start() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.status.Running {
+		return false
+	}
+	b.begin()
+	go func() {
+		if err := b.run(); err != nil {
+			log.Printf("Couldn't index pages: %s\n", err.Error())
+		}
+	}()
+	return true
+}
+
+// Mark a pass as begun; callers must hold the lock
+func (b *indexBuilder) begin() {
+	b.status = indexStatus{Running: true, Started: time.Now()}
+	b.touched = make(map[string]bool)
+}
+
+// Index every page in the store, and drop any the indexes still have that
+// the store doesn't
+func (b *indexBuilder) run() error {
+	err := b.indexAll()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		b.status.Error = err.Error()
+	}
+	b.status.Running = false
+	b.status.Finished = time.Now()
+	b.touched = nil
+	return err
+}
+
+func (b *indexBuilder) indexAll() error {
+	titles, err := store.List()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.status.Total = len(titles)
+	b.mu.Unlock()
+	listed := make(map[string]bool, len(titles))
+	for _, title := range titles {
+		listed[title] = true
+		p, err := loadPage(context.Background(), title)
+		b.mu.Lock()
+		if err == nil && !b.touched[title] {
+			updateIndexes(title, p.Body)
+			lastEdits.load(title)
+		}
+		b.status.Done++
+		b.mu.Unlock()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, title := range titleCases.titles() {
+		if !listed[title] && !b.touched[title] {
+			removeFromIndexes(title)
+		}
+	}
+	return nil
+}
+
+// Scan every page to build the indexes, waiting until it's done, as
+// commands do before working on the pages
+func buildIndexes() error {
+	indexer.mu.Lock()
+	indexer.begin()
+	indexer.mu.Unlock()
+	return indexer.run()
+}
+
+// /admin/status shows how indexing is going, and starts it over if asked
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if indexer.start() {
+			audit(r, "reindex", "", "started rebuilding the indexes")
+		}
+		http.Redirect(w, r, sitePath("/admin/status"), http.StatusFound)
+		return
+	}
+	renderTemplate(w, r, "status", struct {
+		Index indexStatus
+		Pages int
+	}{indexer.progress(), titleCases.len()})
+}
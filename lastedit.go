@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// lastEditIndex remembers each page's latest revision, so loading a page
+// can say who last changed it and when without reading back through its history
+type lastEditIndex struct {
+	mu    sync.RWMutex
+	edits map[string]Revision
+}
+
+var lastEdits = &lastEditIndex{edits: make(map[string]Revision)}
+
+func (li *lastEditIndex) set(title string, rev Revision) {
+	li.mu.Lock()
+	defer li.mu.Unlock()
+	li.edits[title] = rev
+}
+
+func (li *lastEditIndex) remove(title string) {
+	li.mu.Lock()
+	defer li.mu.Unlock()
+	delete(li.edits, title)
+}
+
+// Look the page's latest revision up in its history, as at startup
+func (li *lastEditIndex) load(title string) {
+	revs, err := store.Revisions(title)
+	if err != nil || len(revs) == 0 {
+		li.remove(title)
+		return
+	}
+	li.set(title, revs[len(revs)-1])
+}
+
+// When the page last changed and who by. Pages with no history, like ones
+// copied into the data directory by hand, go by the store's modification
+// time and have no editor.
+func (li *lastEditIndex) lookup(title string) (time.Time, string) {
+	li.mu.RLock()
+	rev, ok := li.edits[title]
+	li.mu.RUnlock()
+	if !ok {
+		return pageModTime(title), ""
+	}
+	return rev.Time, rev.Author
+}
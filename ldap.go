@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapAuth checks logins against an LDAP directory such as Active Directory.
+// It finds the user with the service account, binds as them to check their
+// password, then looks up their groups to decide their role.
+type ldapAuth struct {
+	url        string
+	serverName string
+}
+
+func newLDAPAuth() (*ldapAuth, error) {
+	if config.LDAPURL == "" || config.LDAPBaseDN == "" {
+		return nil, errors.New("the ldap backend needs -ldap-url and -ldap-base-dn")
+	}
+	u, err := url.Parse(config.LDAPURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -ldap-url: %w", err)
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, errors.New("-ldap-url must start with ldap:// or ldaps://")
+	}
+	if !strings.Contains(config.LDAPUserFilter, "%s") || !strings.Contains(config.LDAPGroupFilter, "%s") {
+		return nil, errors.New("-ldap-user-filter and -ldap-group-filter need a %s for the user")
+	}
+	for group, role := range config.LDAPGroupRoles {
+		if role != "admin" && role != "user" {
+			return nil, fmt.Errorf("group %s has unknown role %q: use admin or user", group, role)
+		}
+	}
+	return &ldapAuth{url: config.LDAPURL, serverName: u.Hostname()}, nil
+}
+
+func (a *ldapAuth) canRegister() bool {
+	return false
+}
+
+func (a *ldapAuth) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.url, ldap.DialWithTLSConfig(&tls.Config{ServerName: a.serverName}))
+	if err != nil {
+		return nil, err
+	}
+	if config.LDAPStartTLS {
+		if err := conn.StartTLS(&tls.Config{ServerName: a.serverName}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Bind as the service account, or search anonymously without one
+func (a *ldapAuth) bindService(conn *ldap.Conn) error {
+	if config.LDAPBindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(config.LDAPBindDN, config.LDAPBindPassword)
+}
+
+func (a *ldapAuth) authenticate(username, password string) (*User, error) {
+	// an empty password would be an unauthenticated bind, which many servers accept
+	if password == "" || !validUsername.MatchString(username) {
+		return nil, errBadCredentials
+	}
+	conn, err := a.dial()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't reach the directory: %w", err)
+	}
+	defer conn.Close()
+	if err := a.bindService(conn); err != nil {
+		return nil, fmt.Errorf("couldn't bind to the directory: %w", err)
+	}
+
+	res, err := conn.Search(ldap.NewSearchRequest(config.LDAPBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(config.LDAPUserFilter, ldap.EscapeFilter(username)), []string{"dn"}, nil))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't search the directory: %w", err)
+	}
+	if len(res.Entries) != 1 {
+		return nil, errBadCredentials
+	}
+	dn := res.Entries[0].DN
+	if err := conn.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errBadCredentials
+		}
+		return nil, fmt.Errorf("couldn't check the password: %w", err)
+	}
+
+	role := ""
+	if len(config.LDAPGroupRoles) > 0 {
+		if err := a.bindService(conn); err != nil {
+			return nil, fmt.Errorf("couldn't bind to the directory: %w", err)
+		}
+		groups, err := a.groups(conn, dn)
+		if err != nil {
+			return nil, err
+		}
+		if role = groupRole(groups); role == "" {
+			return nil, errNoRole
+		}
+	}
+	return users.directoryUser("ldap", dn, username, role)
+}
+
+// The DNs of the groups the user belongs to
+func (a *ldapAuth) groups(conn *ldap.Conn, dn string) ([]string, error) {
+	base := config.LDAPGroupBaseDN
+	if base == "" {
+		base = config.LDAPBaseDN
+	}
+	res, err := conn.Search(ldap.NewSearchRequest(base, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(config.LDAPGroupFilter, ldap.EscapeFilter(dn)), []string{"dn"}, nil))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't look up groups: %w", err)
+	}
+	groups := make([]string, 0, len(res.Entries))
+	for _, e := range res.Entries {
+		groups = append(groups, e.DN)
+	}
+	return groups, nil
+}
+
+// The best role any of the groups maps to, or nothing if none of them are mapped.
+// DNs are compared ignoring case, as directories do.
+func groupRole(groups []string) string {
+	role := ""
+	for mapped, r := range config.LDAPGroupRoles {
+		for _, g := range groups {
+			if strings.EqualFold(g, mapped) && (role == "" || r == "admin") {
+				role = r
+			}
+		}
+	}
+	return role
+}
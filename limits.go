@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+var errPageTooLarge = errors.New("the page is too large")
+
+// Uploads and imports carry files, so they're held to the upload limit instead
+func hasOwnBodyLimit(path string) bool {
+	return strings.HasPrefix(path, "/upload/") || path == "/import"
+}
+
+// limitRequestBodies cuts every request body off at -max-request-bytes, so
+// one huge POST can't use up the server's memory. Forms are read straight
+// away, because FormValue would quietly hand back nothing from one cut short.
+func limitRequestBodies(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.MaxRequestBytes <= 0 || hasOwnBodyLimit(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > config.MaxRequestBytes {
+			requestTooLarge(w, r)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, config.MaxRequestBytes)
+		var err error
+		switch mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType {
+		case "application/x-www-form-urlencoded":
+			err = r.ParseForm()
+		case "multipart/form-data":
+			err = r.ParseMultipartForm(config.MaxRequestBytes)
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			requestTooLarge(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func requestTooLarge(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("That was more than the wiki accepts at once: requests can be up to %s.", formatSize(config.MaxRequestBytes))
+	if strings.HasPrefix(r.URL.Path, apiPrefix) {
+		apiError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request bodies are limited to %d bytes", config.MaxRequestBytes))
+		return
+	}
+	// the rest of the body is never read, so don't keep the connection for another request
+	w.Header().Set("Connection", "close")
+	httpError(w, r, http.StatusRequestEntityTooLarge, message)
+}
+
+func pageTooLarge(w http.ResponseWriter, r *http.Request) {
+	httpError(w, r, http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("The page wasn't saved: pages can be up to %s, so try splitting it into several.", formatSize(config.MaxPageBytes)))
+}
+
+// Sizes in the units people expect to read them in
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%d MB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%d KB", n>>10)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
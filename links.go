@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// linkGraph records which pages each page links to, so we can answer
+// "what links here"
+type linkGraph struct {
+	mu    sync.RWMutex
+	links map[string][]string
+}
+
+var links = &linkGraph{links: make(map[string][]string)}
+
+// Pull the distinct [[PageName]] and {{include:PageName}} targets out of a
+// page body. Including a page counts as linking to it.
+func pageLinks(body []byte) []string {
+	var targets []string
+	for _, m := range append(wikiLink.FindAllSubmatch(body, -1), includeLink.FindAllSubmatch(body, -1)...) {
+		target := strings.TrimSpace(string(m[1]))
+		if validTitle(target) && !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+func (g *linkGraph) update(title string, body []byte) {
+	targets := pageLinks(body)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.links[title] = targets
+}
+
+func (g *linkGraph) remove(title string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.links, title)
+}
+
+// The pages linking to title, sorted. Links written in another case count,
+// unless they're closer to another page's title.
+func (g *linkGraph) backlinks(title string) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var sources []string
+	for source, targets := range g.links {
+		if source != title && slices.ContainsFunc(targets, func(target string) bool {
+			return target == title || strings.EqualFold(target, title) && resolveTitle(target) == title
+		}) {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+func backlinksHandler(w http.ResponseWriter, r *http.Request, title string) {
+	renderTemplate(w, r, "backlinks", struct {
+		Title     string
+		Backlinks []string
+	}{title, readableTitles(r, links.backlinks(title))})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Addresses starting with this are Unix sockets, e.g. unix:/run/gowiki.sock
+const unixPrefix = "unix:"
+
+// Everywhere the wiki is served: -addr, then each of -listen
+func listenAddrs() []string {
+	addrs := []string{config.Addr}
+	for _, addr := range config.Listen {
+		if addr != config.Addr {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func unixSocket(addr string) (string, bool) {
+	return strings.CutPrefix(addr, unixPrefix)
+}
+
+// Start listening on an address. A socket left behind by an earlier run is
+// removed first, as nothing can listen on it until it's gone.
+func listen(addr string) (net.Listener, error) {
+	path, ok := unixSocket(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New(path + " is already there and isn't a socket")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// Did the request come in over a Unix socket? Only something on the same
+// machine, such as a reverse proxy, can have sent it.
+func overUnixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// How long sending a preview back may take before the connection is given up on
+const livePreviewWriteTimeout = 30 * time.Second
+
+// The edit page sends the body it's editing over a WebSocket as the user
+// types, and gets the rendered HTML back to show beside it. While it's
+// connected, the user counts as editing the page.
+func livePreviewHandler(w http.ResponseWriter, r *http.Request, title string) {
+	user, l := username(r), requestLocale(r)
+	server := websocket.Server{
+		Handshake: checkSameOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			// the server's timeouts are for requests, and an edit goes on for as
+			// long as it takes, so waiting for the next body never times out
+			if err := ws.SetDeadline(time.Time{}); err != nil {
+				return
+			}
+			editing.join(title, user)
+			defer editing.leave(title, user)
+			// nothing bigger could be saved, so there's no point previewing it
+			ws.MaxPayloadBytes = int(config.MaxPageBytes)
+			for {
+				var body string
+				if err := websocket.Message.Receive(ws, &body); err != nil {
+					if !errors.Is(err, io.EOF) {
+						log.Printf("Live preview of %s ended: %s\n", title, err.Error())
+					}
+					return
+				}
+				ws.SetWriteDeadline(time.Now().Add(livePreviewWriteTimeout))
+				if err := websocket.Message.Send(ws, string(renderMarkup(l, title, []byte(body)))); err != nil {
+					return
+				}
+			}
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// Browsers let any page open a WebSocket, so only accept our own pages,
+// which carry the session cookie along
+func checkSameOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || origin.Host != r.Host {
+		return errors.New("cross-origin WebSocket refused")
+	}
+	config.Origin = origin
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// A preview socket outlasts the server's read and write timeouts, as an
+// edit usually does
+func TestLivePreviewOutlastsTimeouts(t *testing.T) {
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		livePreviewHandler(w, r, "Preview")
+	}))
+	srv.Config.ReadTimeout = 100 * time.Millisecond
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	time.Sleep(300 * time.Millisecond)
+	if err := websocket.Message.Send(ws, "**still here**"); err != nil {
+		t.Fatal(err)
+	}
+	var html string
+	if err := websocket.Message.Receive(ws, &html); err != nil {
+		t.Fatalf("no preview after the server's timeouts: %s", err)
+	}
+	if !strings.Contains(html, "<strong>still here</strong>") {
+		t.Errorf("got preview %q", html)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Request logs go here, one structured line per request
+var accessLog = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+func openAccessLog() error {
+	var out io.Writer = os.Stderr
+	if config.AccessLog != "" {
+		f, err := os.OpenFile(config.AccessLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+
+	switch config.LogFormat {
+	case "text", "":
+		accessLog = slog.New(slog.NewTextHandler(out, nil))
+	case "json":
+		accessLog = slog.New(slog.NewJSONHandler(out, nil))
+	default:
+		return fmt.Errorf("unknown log format %q", config.LogFormat)
+	}
+	return nil
+}
+
+// responseRecorder remembers the status and size of a response as it's written
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Let http.ResponseController reach the underlying writer
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Hijack hands the connection over for WebSockets, which take it over after a 101
+func (rec *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil && rec.status == 0 {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// The client's address, as passed on by a trusted proxy if it came through one
+func clientIP(r *http.Request) string {
+	if fromTrustedProxy(r) {
+		if ip := forwardedFor(r); ip != "" {
+			return ip
+		}
+	}
+	return peerIP(r)
+}
+
+// logging middleware
+func logRequestHandler(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+
+		// call the original handler we're wrapping
+		h.ServeHTTP(rec, r)
+
+		// a handler that writes nothing still sends a 200
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		accessLog.Info("request",
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"ip", clientIP(r),
+			"user_agent", r.UserAgent(),
+			"request_id", requestID(r),
+		)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// How many messages can wait to be sent before new ones are dropped
+const mailQueueSize = 100
+
+// A failed send is retried this many times, waiting twice as long each time
+const (
+	mailAttempts   = 5
+	mailRetryDelay = 30 * time.Second
+)
+
+// A message waiting in the queue
+type mailMessage struct {
+	To       string
+	Subject  string
+	Body     string
+	attempts int
+}
+
+var (
+	mailTemplates map[string]*template.Template // by locale
+	mailQueue     = make(chan mailMessage, mailQueueSize)
+)
+
+// Mail templates live in templates/mail as text/template files. Each starts
+// with a "Subject:" line, then a blank line, then the body. Like the pages,
+// they're translated with t, into the language of whoever they're going to.
+func parseMailTemplates(l *locale) (*template.Template, error) {
+	return template.New("mail").Funcs(template.FuncMap{"base": basePath}).Funcs(l.funcs()).ParseFS(templateFS(), "mail/*.txt")
+}
+
+// Load a set of mail templates for each locale; the catalogs are loaded with the page templates
+func loadMailTemplates() error {
+	sets := make(map[string]*template.Template, len(locales))
+	for code, l := range locales {
+		t, err := parseMailTemplates(l)
+		if err != nil {
+			return err
+		}
+		sets[code] = t
+	}
+	mailTemplates = sets
+	return nil
+}
+
+func mailEnabled() bool {
+	return config.SMTPHost != ""
+}
+
+// Where the wiki can be reached, for links in messages sent outside any request
+func siteURL() string {
+	if config.BaseURL != "" {
+		// links already carry the base path, so it comes off here if it's been given
+		return strings.TrimSuffix(strings.TrimSuffix(config.BaseURL, "/"), config.BasePath)
+	}
+	host, port, err := net.SplitHostPort(config.Addr)
+	if err != nil {
+		return "http://localhost"
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// Render a mail template and queue the message. Mail is best effort: with no
+// SMTP server configured, or a full queue, the message is logged and dropped.
+func sendMail(to string, l *locale, tmpl string, data map[string]any) {
+	if !mailEnabled() || to == "" {
+		return
+	}
+	t := mailTemplates[l.Code]
+	if config.Dev {
+		fresh, err := readLocale(templateFS(), l.Code)
+		if err == nil {
+			t, err = parseMailTemplates(fresh)
+		}
+		if err != nil {
+			log.Printf("Couldn't load mail templates: %s\n", err.Error())
+			return
+		}
+	}
+	if data == nil {
+		data = make(map[string]any)
+	}
+	data["SiteURL"] = siteURL()
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, tmpl+".txt", data); err != nil {
+		log.Printf("Couldn't render %s mail: %s\n", tmpl, err.Error())
+		return
+	}
+	header, body, _ := strings.Cut(buf.String(), "\n\n")
+	subject, ok := strings.CutPrefix(header, "Subject: ")
+	if !ok {
+		log.Printf("Mail template %s doesn't start with a Subject line\n", tmpl)
+		return
+	}
+	enqueueMail(mailMessage{To: to, Subject: strings.TrimSpace(subject), Body: body})
+}
+
+func enqueueMail(m mailMessage) {
+	select {
+	case mailQueue <- m:
+	default:
+		log.Printf("Mail queue is full, dropping %q to %s\n", m.Subject, m.To)
+	}
+}
+
+// Send queued mail one message at a time, putting failures back on the queue after a wait
+func runMailer() {
+	for m := range mailQueue {
+		err := deliver(m)
+		if err == nil {
+			continue
+		}
+		m.attempts++
+		if m.attempts >= mailAttempts {
+			log.Printf("Giving up on %q to %s after %d attempts: %s\n", m.Subject, m.To, m.attempts, err.Error())
+			continue
+		}
+		delay := mailRetryDelay << (m.attempts - 1)
+		log.Printf("Couldn't send %q to %s, retrying in %s: %s\n", m.Subject, m.To, delay, err.Error())
+		time.AfterFunc(delay, func() { enqueueMail(m) })
+	}
+}
+
+// Hand a message to the SMTP server. smtp.SendMail upgrades to TLS when the server offers it.
+func deliver(m mailMessage) error {
+	var auth smtp.Auth
+	if config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, config.SMTPHost)
+	}
+	msg, err := formatMail(m)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(config.SMTPHost, strconv.Itoa(config.SMTPPort))
+	return smtp.SendMail(addr, auth, config.MailFrom, []string{m.To}, msg)
+}
+
+func formatMail(m mailMessage) ([]byte, error) {
+	// a newline in an address would let it smuggle in headers of its own
+	if strings.ContainsAny(m.To+config.MailFrom, "\r\n") {
+		return nil, errors.New("mail addresses can't contain line breaks")
+	}
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	_, domain, _ := strings.Cut(config.MailFrom, "@")
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", config.MailFrom)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	// the SMTP client takes care of line endings and dot-stuffing in the body
+	buf.WriteString(m.Body)
+	return buf.Bytes(), nil
+}
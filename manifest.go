@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+// The web app manifest lets phones and desktop browsers install the wiki
+// like an app, with its own icon and name, opening in a window of its own.
+type webManifest struct {
+	Name            string         `json:"name"`
+	ShortName       string         `json:"short_name"`
+	StartURL        string         `json:"start_url"`
+	Scope           string         `json:"scope"`
+	Display         string         `json:"display"`
+	ThemeColor      string         `json:"theme_color"`
+	BackgroundColor string         `json:"background_color"`
+	Icons           []manifestIcon `json:"icons"`
+}
+
+type manifestIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// The templates' themeColor function, for the theme-color meta tag
+func themeColor() string {
+	return config.ThemeColor
+}
+
+// /manifest.webmanifest describes the wiki for installing it
+func manifestHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(webManifest{
+		Name:            siteName(),
+		ShortName:       siteName(),
+		StartURL:        sitePath("/"),
+		Scope:           sitePath("/"),
+		Display:         "standalone",
+		ThemeColor:      config.ThemeColor,
+		BackgroundColor: "#ffffff",
+		Icons: []manifestIcon{
+			{Src: sitePath("/static/icons/icon-192.png"), Sizes: "192x192", Type: "image/png"},
+			{Src: sitePath("/static/icons/icon-512.png"), Sizes: "512x512", Type: "image/png"},
+			{Src: sitePath("/static/icons/icon-maskable-512.png"), Sizes: "512x512", Type: "image/png", Purpose: "maskable"},
+		},
+	})
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.Header().Set("Cache-Control", "public, max-age="+staticMaxAge)
+	w.Write(data)
+}
+
+// /favicon.ico is asked for by browsers whether pages link it or not, so it's
+// served from the static icons at the top level too
+func faviconHandler(w http.ResponseWriter, r *http.Request) {
+	icon, err := fs.ReadFile(staticFS(), "icons/favicon.ico")
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/x-icon")
+	if config.Dev {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age="+staticMaxAge)
+	}
+	http.ServeContent(w, r, "favicon.ico", time.Time{}, bytes.NewReader(icon))
+}
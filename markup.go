@@ -0,0 +1,83 @@
+package main
+
+import (
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+var (
+	wikiLink   = regexp.MustCompile(`\[\[([^\[\]]+)\]\]`)
+	attachLink = regexp.MustCompile(`\{\{attach:([a-zA-Z0-9_-][a-zA-Z0-9._-]*)\}\}`)
+	tagLink    = regexp.MustCompile(`\{\{tag:([^{}]+)\}\}`)
+)
+
+// Render a page body to HTML: the text is escaped, # lines become headings,
+// lines starting with | become tables and with - or 1. become lists,
+// **bold**, *italic* and `code` are formatted, [[PageName]] becomes a link, pointing
+// at the editor for pages that don't exist yet, {{attach:name}} embeds one of
+// the page's attachments and {{tag:name}} tags the page. Pages with enough
+// headings start with a table of contents unless they say {{notoc}}, and
+// {{include:PageName}} brings in another page's content. Code goes between
+// ``` fences, which draw diagrams in mermaid and graphviz blocks, and with
+// math turned on, $...$ and $$...$$ are TeX. Shortcodes like :smile: become emoji.
+// The table of contents and any notices about includes are in the locale's words.
+func renderMarkup(l *locale, title string, body []byte) template.HTML {
+	return renderIncluded(l, title, body, nil)
+}
+
+// Render a page that's being included by the pages in including. Only the
+// outermost page gets a table of contents.
+func renderIncluded(l *locale, title string, body []byte, including []string) template.HTML {
+	_, _, body = splitFrontMatter(body)
+	var held placeholders
+	escaped := extractFences([]byte(template.HTMLEscapeString(string(body))), &held)
+	escaped = extractMath(escaped, &held)
+	escaped = extractInlineCode(escaped, &held)
+	out, headings := renderHeadings(escaped)
+	out = renderTables(out)
+	out = renderLists(out)
+	if noTOC.Match(out) {
+		out = noTOC.ReplaceAll(out, nil)
+	} else if len(headings) >= minTOCHeadings && len(including) == 0 {
+		out = append(renderTOC(l, headings), out...)
+	}
+	out = wikiLink.ReplaceAllFunc(out, func(link []byte) []byte {
+		// the text is already escaped, but valid titles have nothing that escaping changes
+		target := strings.TrimSpace(string(wikiLink.FindSubmatch(link)[1]))
+		if !validTitle(target) {
+			return link
+		}
+		if canonical, ok := titleCases.resolve(target); ok {
+			return []byte(`<a class="wikilink" href="` + pageURL("view", canonical) + `">` + target + `</a>`)
+		}
+		return []byte(`<a class="wikilink missing" href="` + pageURL("edit", target) + `">` + target + `</a>`)
+	})
+	out = attachLink.ReplaceAllFunc(out, func(link []byte) []byte {
+		name := string(attachLink.FindSubmatch(link)[1])
+		src := pageURL("attachments", title) + "/" + name
+		if isImage(name) {
+			return []byte(`<img class="attachment" src="` + src + `" alt="` + name + `">`)
+		}
+		return []byte(`<a class="attachment" href="` + src + `">` + name + `</a>`)
+	})
+	out = tagLink.ReplaceAllFunc(out, func(link []byte) []byte {
+		tag, ok := normalizeTag(string(tagLink.FindSubmatch(link)[1]))
+		if !ok {
+			return link
+		}
+		return []byte(`<a class="tag" href="` + pageURL("tag", tag) + `">` + tag + `</a>`)
+	})
+	out = renderEmphasis(out)
+	out = renderEmoji(out)
+	// after the rules above, so the included pages' HTML isn't run through them
+	// again, but before the code comes back, so code can show the directive
+	out = renderIncludes(l, out, append(including, title))
+	out = held.restore(out)
+	return template.HTML(out)
+}
+
+// HTML renders the page body for display in a locale
+func (p *Page) HTML(l *locale) template.HTML {
+	return renders.render(l, p.Title, p.Body)
+}
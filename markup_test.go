@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Code shows an include directive as it's written, so pages can document it
+func TestIncludeInCode(t *testing.T) {
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{
+		"```\n{{include:Other}}\n```",
+		"Write `{{include:Other}}` to include Other.",
+	} {
+		html := string(renderMarkup(locales[config.Language], "Doc", []byte(body)))
+		if !strings.Contains(html, "{{include:Other}}") || strings.Contains(html, "include-error") || strings.Contains(html, `class="include"`) {
+			t.Errorf("%q rendered the include:\n%s", body, html)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+var (
+	// $$ blocks may run over several lines; inline $ math stays on one and
+	// can't start or end with a space, so prices like $5 and $10 are left alone
+	displayMath = regexp.MustCompile(`(?s)\$\$(.+?)\$\$`)
+	inlineMath  = regexp.MustCompile(`\$([^\s$](?:[^$\n]*[^\s$])?)\$`)
+)
+
+// Set the page's math aside, so no other markup rule touches the TeX inside
+// it. The browser typesets it with KaTeX, built in under static/vendor and
+// loaded by static/math.js.
+func extractMath(escaped []byte, held *placeholders) []byte {
+	if !config.Math {
+		return escaped
+	}
+	out := displayMath.ReplaceAllFunc(escaped, func(m []byte) []byte {
+		return held.mark(`<div class="math math-display">` + string(bytes.TrimSpace(displayMath.FindSubmatch(m)[1])) + `</div>`)
+	})
+	return inlineMath.ReplaceAllFunc(out, func(m []byte) []byte {
+		return held.mark(`<span class="math math-inline">` + string(inlineMath.FindSubmatch(m)[1]) + `</span>`)
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// A title as it appears in a hierarchy: its last part, how deeply it's
+// nested, and whether there's a page by that title or it's only a namespace
+type titlePart struct {
+	Title  string
+	Name   string
+	Depth  int
+	Exists bool
+}
+
+// The namespaces above a page, for breadcrumbs
+func breadcrumbs(title string) []titlePart {
+	var crumbs []titlePart
+	for i, ns := range titleNamespaces(title) {
+		crumbs = append(crumbs, titlePart{Title: ns, Name: titleName(ns), Depth: i, Exists: pageExists(ns)})
+	}
+	return crumbs
+}
+
+// The pages and namespaces directly under a page, for listing at its foot.
+// Pages the user can't read are left out.
+func namespaceChildren(r *http.Request, title string) ([]titlePart, error) {
+	var pages, namespaces []string
+	if l, ok := store.(namespaceLister); ok {
+		var err error
+		if pages, namespaces, err = l.ListNamespace(title); err != nil {
+			return nil, err
+		}
+	} else {
+		titles, err := store.List()
+		if err != nil {
+			return nil, err
+		}
+		prefix := title + namespaceSeparator
+		for _, t := range titles {
+			rest, ok := strings.CutPrefix(t, prefix)
+			if !ok {
+				continue
+			}
+			if name, _, nested := strings.Cut(rest, namespaceSeparator); nested {
+				if ns := prefix + name; !slices.Contains(namespaces, ns) {
+					namespaces = append(namespaces, ns)
+				}
+			} else {
+				pages = append(pages, t)
+			}
+		}
+	}
+	pages = readableTitles(r, pages)
+	var children []titlePart
+	for _, t := range pages {
+		children = append(children, titlePart{Title: t, Name: titleName(t), Depth: len(titleNamespaces(t)), Exists: true})
+	}
+	// a nested namespace with a page of its own is listed once, as that page
+	for _, ns := range namespaces {
+		if !slices.Contains(pages, ns) {
+			children = append(children, titlePart{Title: ns, Name: titleName(ns), Depth: len(titleNamespaces(ns))})
+		}
+	}
+	slices.SortFunc(children, func(a, b titlePart) int { return compareTitles(a.Title, b.Title) })
+	return children, nil
+}
+
+// Lay sorted titles out as a tree, adding an entry for each namespace that
+// has no page of its own so its pages still hang off something
+func titleTree(titles []string) []titlePart {
+	exists := make(map[string]bool, len(titles))
+	for _, title := range titles {
+		exists[title] = true
+	}
+	var tree []titlePart
+	listed := make(map[string]bool)
+	for _, title := range titles {
+		for i, ns := range titleNamespaces(title) {
+			if !listed[ns] {
+				tree = append(tree, titlePart{Title: ns, Name: titleName(ns), Depth: i, Exists: exists[ns]})
+				listed[ns] = true
+			}
+		}
+		if !listed[title] {
+			tree = append(tree, titlePart{Title: title, Name: titleName(title), Depth: len(titleNamespaces(title)), Exists: true})
+			listed[title] = true
+		}
+	}
+	return tree
+}
+
+// Name is the page's title without its namespaces
+func (p *Page) Name() string {
+	return titleName(p.Title)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+const oidcStateCookie = "oidc_state"
+
+var (
+	oidcPath          = regexp.MustCompile("^/login/oidc/([a-zA-Z0-9_-]+)(/callback)?$")
+	validProviderName = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+)
+
+// An external identity provider people can log in with. Type is google,
+// github or oidc; generic OIDC providers are found through their issuer's
+// discovery document. UsernameClaim picks the userinfo claim the wiki
+// username is made from, defaulting to something sensible for the type.
+type OIDCProvider struct {
+	Name          string   `yaml:"name"`
+	Label         string   `yaml:"label"`
+	Type          string   `yaml:"type"`
+	Issuer        string   `yaml:"issuer"`
+	ClientID      string   `yaml:"client_id"`
+	ClientSecret  string   `yaml:"client_secret"`
+	RedirectURL   string   `yaml:"redirect_url"`
+	Scopes        []string `yaml:"scopes"`
+	UsernameClaim string   `yaml:"username_claim"`
+}
+
+func (p OIDCProvider) DisplayName() string {
+	if p.Label != "" {
+		return p.Label
+	}
+	return p.Name
+}
+
+// The parts of a discovery document the login flow needs
+type oidcEndpoints struct {
+	AuthURL     string `json:"authorization_endpoint"`
+	TokenURL    string `json:"token_endpoint"`
+	UserInfoURL string `json:"userinfo_endpoint"`
+}
+
+// Discovery documents are fetched on first use and then remembered
+var discovered = struct {
+	sync.Mutex
+	endpoints map[string]oidcEndpoints
+}{endpoints: make(map[string]oidcEndpoints)}
+
+func discover(ctx context.Context, issuer string) (oidcEndpoints, error) {
+	discovered.Lock()
+	defer discovered.Unlock()
+	if e, ok := discovered.endpoints[issuer]; ok {
+		return e, nil
+	}
+	var e oidcEndpoints
+	if err := getJSON(ctx, http.DefaultClient, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &e); err != nil {
+		return e, err
+	}
+	if e.AuthURL == "" || e.TokenURL == "" || e.UserInfoURL == "" {
+		return e, fmt.Errorf("discovery document for %s is missing an endpoint", issuer)
+	}
+	discovered.endpoints[issuer] = e
+	return e, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func findProvider(name string) (OIDCProvider, bool) {
+	for _, p := range config.OIDCProviders {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return OIDCProvider{}, false
+}
+
+// Fill in what each provider type implies, and check nothing needed is missing
+func checkProviders(providers []OIDCProvider) error {
+	seen := make(map[string]bool)
+	for i := range providers {
+		p := &providers[i]
+		if !validProviderName.MatchString(p.Name) {
+			return fmt.Errorf("identity provider name %q may only contain letters, digits, '_' and '-'", p.Name)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("there's more than one identity provider called %s", p.Name)
+		}
+		seen[p.Name] = true
+		switch p.Type {
+		case "google":
+			p.Issuer = "https://accounts.google.com"
+			if len(p.Scopes) == 0 {
+				p.Scopes = []string{"openid", "email", "profile"}
+			}
+			if p.UsernameClaim == "" {
+				p.UsernameClaim = "email"
+			}
+		case "github":
+			if p.UsernameClaim == "" {
+				p.UsernameClaim = "login"
+			}
+		case "oidc":
+			if p.Issuer == "" {
+				return fmt.Errorf("identity provider %s needs an issuer", p.Name)
+			}
+			if len(p.Scopes) == 0 {
+				p.Scopes = []string{"openid", "email", "profile"}
+			}
+			if p.UsernameClaim == "" {
+				p.UsernameClaim = "preferred_username"
+			}
+		default:
+			return fmt.Errorf("identity provider %s has unknown type %q: use google, github or oidc", p.Name, p.Type)
+		}
+		if p.ClientID == "" || p.ClientSecret == "" {
+			return fmt.Errorf("identity provider %s needs a client_id and client_secret", p.Name)
+		}
+	}
+	return nil
+}
+
+// Build the OAuth2 client settings for a provider, and where to ask who logged in.
+// Unless it's configured, the callback is on whichever host the login started from.
+func (p OIDCProvider) oauth(ctx context.Context, r *http.Request) (*oauth2.Config, string, error) {
+	redirect := p.RedirectURL
+	if redirect == "" {
+		redirect = requestScheme(r) + "://" + r.Host + sitePath("/login/oidc/"+p.Name+"/callback")
+	}
+	conf := &oauth2.Config{ClientID: p.ClientID, ClientSecret: p.ClientSecret, RedirectURL: redirect, Scopes: p.Scopes}
+	if p.Type == "github" {
+		conf.Endpoint = github.Endpoint
+		return conf, "https://api.github.com/user", nil
+	}
+	e, err := discover(ctx, p.Issuer)
+	if err != nil {
+		return nil, "", err
+	}
+	conf.Endpoint = oauth2.Endpoint{AuthURL: e.AuthURL, TokenURL: e.TokenURL}
+	return conf, e.UserInfoURL, nil
+}
+
+// Who the provider says logged in: a stable subject identifying them there,
+// and the username they'd like on the wiki
+func (p OIDCProvider) identify(claims map[string]any) (subject, name string, err error) {
+	// GitHub isn't OIDC, so its users have a numeric id rather than a sub
+	subject = claimString(claims, "sub")
+	if p.Type == "github" {
+		subject = claimString(claims, "id")
+	}
+	if subject == "" {
+		return "", "", errors.New("the identity provider didn't say who you are")
+	}
+	if p.Type == "google" && p.UsernameClaim == "email" && claims["email_verified"] != true {
+		return "", "", errors.New("your Google account's email address isn't verified")
+	}
+	return subject, claimUsername(claimString(claims, p.UsernameClaim)), nil
+}
+
+func claimString(claims map[string]any, name string) string {
+	switch v := claims[name].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+var invalidUsernameChars = regexp.MustCompile("[^a-zA-Z0-9_.-]+")
+
+// Turn a claim into a wiki username: the part of an email address before the @,
+// with anything usernames can't contain replaced by '-'
+func claimUsername(claim string) string {
+	if local, _, ok := strings.Cut(claim, "@"); ok {
+		claim = local
+	}
+	name := strings.Trim(invalidUsernameChars.ReplaceAllString(claim, "-"), "-")
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
+
+// Remember the state and PKCE verifier of a login in progress until the provider sends the browser back
+type oidcState struct {
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next"`
+}
+
+func newState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func setStateCookie(w http.ResponseWriter, r *http.Request, provider string, s oidcState) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    hex.EncodeToString(data),
+		Path:     sitePath("/login/oidc/" + provider),
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func readStateCookie(w http.ResponseWriter, r *http.Request, provider string) (oidcState, bool) {
+	var s oidcState
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return s, false
+	}
+	// each login gets one try
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: sitePath("/login/oidc/" + provider), MaxAge: -1})
+	data, err := hex.DecodeString(c.Value)
+	if err != nil || json.Unmarshal(data, &s) != nil || s.State == "" {
+		return s, false
+	}
+	return s, true
+}
+
+// /login/oidc/<provider> sends the browser off to log in, and the provider sends it back to
+// /login/oidc/<provider>/callback, where the first login creates the wiki account
+func oidcHandler(w http.ResponseWriter, r *http.Request) {
+	m := oidcPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		notFound(w, r)
+		return
+	}
+	p, ok := findProvider(m[1])
+	if !ok {
+		notFound(w, r)
+		return
+	}
+	conf, userInfoURL, err := p.oauth(r.Context(), r)
+	if err != nil {
+		log.Printf("Couldn't reach identity provider %s: %s\n", p.Name, err.Error())
+		httpError(w, r, http.StatusBadGateway, "Couldn't reach "+p.DisplayName()+" to log you in. Try again later.")
+		return
+	}
+
+	if m[2] == "" {
+		state, err := newState()
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		s := oidcState{State: state, Verifier: oauth2.GenerateVerifier(), Next: safeNext(r.FormValue("next"))}
+		if err := setStateCookie(w, r, p.Name, s); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, conf.AuthCodeURL(s.State, oauth2.S256ChallengeOption(s.Verifier)), http.StatusFound)
+		return
+	}
+
+	s, ok := readStateCookie(w, r, p.Name)
+	if !ok || r.FormValue("state") != s.State {
+		httpError(w, r, http.StatusBadRequest, "That login has expired or didn't start here. Please try logging in again.")
+		return
+	}
+	if reason := r.FormValue("error"); reason != "" {
+		httpError(w, r, http.StatusUnauthorized, p.DisplayName()+" didn't log you in: "+reason)
+		return
+	}
+	token, err := conf.Exchange(r.Context(), r.FormValue("code"), oauth2.VerifierOption(s.Verifier))
+	if err != nil {
+		log.Printf("Couldn't exchange %s login code: %s\n", p.Name, err.Error())
+		httpError(w, r, http.StatusBadGateway, p.DisplayName()+" wouldn't confirm your login. Please try again.")
+		return
+	}
+	var claims map[string]any
+	if err := getJSON(r.Context(), conf.Client(r.Context(), token), userInfoURL, &claims); err != nil {
+		log.Printf("Couldn't fetch %s user info: %s\n", p.Name, err.Error())
+		httpError(w, r, http.StatusBadGateway, "Couldn't find out from "+p.DisplayName()+" who you are. Please try again.")
+		return
+	}
+	subject, name, err := p.identify(claims)
+	if err != nil {
+		httpError(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+	email := ""
+	if verified, _ := claims["email_verified"].(bool); verified || p.Type == "github" {
+		email = claimString(claims, "email")
+	}
+	user, err := users.provision(p.Name, subject, name, email)
+	if err != nil {
+		httpError(w, r, http.StatusConflict, "Couldn't create your account: "+err.Error()+".")
+		return
+	}
+	if err := startSession(w, r, user.Username); err != nil {
+		serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, sitePath(s.Next), http.StatusFound)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A content template new pages can start from, read from templates/pagetypes.
+// Its name is the file name without .txt, e.g. meeting-notes.
+type pageType struct {
+	Name  string
+	Label string
+}
+
+// List the available page types, read afresh so new ones show up without a restart
+func listPageTypes() ([]pageType, error) {
+	files, err := fs.ReadDir(templateFS(), "pagetypes")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var types []pageType
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".txt")
+		if !ok || file.IsDir() || !attachmentName.MatchString(name) {
+			continue
+		}
+		label := strings.ReplaceAll(name, "-", " ")
+		types = append(types, pageType{Name: name, Label: strings.ToUpper(label[:1]) + label[1:]})
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types, nil
+}
+
+func loadPageType(name string) ([]byte, error) {
+	if !attachmentName.MatchString(name) {
+		return nil, os.ErrNotExist
+	}
+	return fs.ReadFile(templateFS(), "pagetypes/"+name+".txt")
+}
+
+// GET shows the form for starting a page, which sends the title and type
+// back here to be passed on to the editor
+func newHandler(w http.ResponseWriter, r *http.Request) {
+	title := strings.TrimSpace(r.FormValue("title"))
+	var problem string
+	if title != "" {
+		switch {
+		case !validTitle(title):
+			problem = "Titles may only use letters, digits, single spaces, '-' and '_'."
+		case pageExists(resolveTitle(title)):
+			problem = "There's already a page called " + resolveTitle(title) + "."
+		default:
+			target := pageURL("edit", title)
+			if t := r.FormValue("type"); t != "" {
+				target += "?" + url.Values{"type": {t}}.Encode()
+			}
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+	}
+	types, err := listPageTypes()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if problem != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	renderTemplate(w, r, "new", struct {
+		Title string
+		Error string
+		Types []pageType
+	}{title, problem, types})
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/gomonobolditalic"
+	"golang.org/x/image/font/gofont/gomonoitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Text sizes in points. Headings follow the levels renderHeadings gives them.
+const (
+	pdfTextSize  = 11
+	pdfCodeSize  = 9
+	pdfTitleSize = 22
+)
+
+var pdfHeadingSizes = map[atom.Atom]float64{
+	atom.H1: 20, atom.H2: 18, atom.H3: 16, atom.H4: 14, atom.H5: 12, atom.H6: 11,
+}
+
+// The colours of static/wiki.css and the Foundation styles under it
+var (
+	pdfLinkColor    = [3]int{0x17, 0x79, 0xba}
+	pdfMissingColor = [3]int{0xcc, 0x4b, 0x37}
+	pdfMutedColor   = [3]int{0x8a, 0x8a, 0x8a}
+	pdfShadeColor   = [3]int{0xf6, 0xf6, 0xf6}
+	pdfBorderColor  = [3]int{0xe6, 0xe6, 0xe6}
+)
+
+// /export/pdf/<title> renders a page to PDF for sharing outside the wiki.
+// Links point back at the wiki, and math and diagrams come out as their source,
+// since there's no browser here to typeset them.
+func pdfHandler(w http.ResponseWriter, r *http.Request) {
+	title := strings.TrimPrefix(r.URL.Path, "/export/pdf/")
+	if !validTitle(title) {
+		notFound(w, r)
+		return
+	}
+	if !checkPermission(w, r, title, permRead) {
+		return
+	}
+	p, err := loadPage(r.Context(), title)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	var buf bytes.Buffer
+	if err := writePDF(&buf, p, requestLocale(r)); err != nil {
+		serverError(w, r, err)
+		return
+	}
+	name := strings.ReplaceAll(title, "/", "-") + ".pdf"
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": name}))
+	w.Write(buf.Bytes())
+}
+
+func writePDF(buf *bytes.Buffer, p *Page, l *locale) error {
+	nodes, err := html.ParseFragment(strings.NewReader(string(p.HTML(l))), &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div})
+	if err != nil {
+		return err
+	}
+	doc := newPDFDoc(p.Title)
+	for _, n := range nodes {
+		doc.walk(n)
+	}
+	return doc.pdf.Output(buf)
+}
+
+// pdfDoc lays a rendered page out as PDF, keeping track of the
+// inline styles and lists it's inside as it walks the HTML
+type pdfDoc struct {
+	pdf     *fpdf.Fpdf
+	size    float64
+	bold    int
+	italic  int
+	mono    int
+	href    string
+	missing bool
+	lists   []int // the next number in each open list, or 0 for bullets and -1 for none
+	anchors map[string]int
+}
+
+func newPDFDoc(title string) *pdfDoc {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	for _, font := range []struct {
+		family, style string
+		ttf           []byte
+	}{
+		{"go", "", goregular.TTF}, {"go", "B", gobold.TTF}, {"go", "I", goitalic.TTF}, {"go", "BI", gobolditalic.TTF},
+		{"gomono", "", gomono.TTF}, {"gomono", "B", gomonobold.TTF}, {"gomono", "I", gomonoitalic.TTF}, {"gomono", "BI", gomonobolditalic.TTF},
+	} {
+		pdf.AddUTF8FontFromBytes(font.family, font.style, font.ttf)
+	}
+	title = pdfText(title)
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("gowiki", true)
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.SetFont("go", "", 8)
+		pdf.SetTextColor(pdfMutedColor[0], pdfMutedColor[1], pdfMutedColor[2])
+		pdf.CellFormat(0, 10, title+" · "+siteURL()+pageURL("view", title), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 10, strconv.Itoa(pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AddPage()
+
+	doc := &pdfDoc{pdf: pdf, size: pdfTitleSize, anchors: make(map[string]int)}
+	doc.setFont()
+	pdf.Write(doc.lineHeight(), title)
+	pdf.Ln(doc.lineHeight())
+	doc.size = pdfTextSize
+	return doc
+}
+
+func (d *pdfDoc) setFont() {
+	family := "go"
+	if d.mono > 0 {
+		family = "gomono"
+	}
+	style := ""
+	if d.bold > 0 {
+		style += "B"
+	}
+	if d.italic > 0 {
+		style += "I"
+	}
+	d.pdf.SetFont(family, style, d.size)
+	color := [3]int{0x0a, 0x0a, 0x0a}
+	switch {
+	case d.href != "" && d.missing:
+		color = pdfMissingColor
+	case d.href != "":
+		color = pdfLinkColor
+	}
+	d.pdf.SetTextColor(color[0], color[1], color[2])
+}
+
+// Lines are spaced like the wiki's body text
+func (d *pdfDoc) lineHeight() float64 {
+	return d.size * 25.4 / 72 * 1.5
+}
+
+func (d *pdfDoc) leftMargin() float64 {
+	left, _, _, _ := d.pdf.GetMargins()
+	return left
+}
+
+func (d *pdfDoc) contentWidth() float64 {
+	width, _ := d.pdf.GetPageSize()
+	left, _, right, _ := d.pdf.GetMargins()
+	return width - left - right
+}
+
+func (d *pdfDoc) atLineStart() bool {
+	return d.pdf.GetX() <= d.leftMargin()+0.01
+}
+
+// Start a new line, unless we're at the start of one already
+func (d *pdfDoc) block() {
+	if !d.atLineStart() {
+		d.pdf.Ln(d.lineHeight())
+	}
+}
+
+// The internal link for an anchor, made the first time either the anchor
+// or a link to it turns up
+func (d *pdfDoc) anchor(id string) int {
+	link, ok := d.anchors[id]
+	if !ok {
+		link = d.pdf.AddLink()
+		d.anchors[id] = link
+	}
+	return link
+}
+
+func (d *pdfDoc) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		d.text(n.Data)
+	case html.ElementNode:
+		d.element(n)
+	default:
+		d.children(n)
+	}
+}
+
+func (d *pdfDoc) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		d.walk(c)
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(n *html.Node, class string) bool {
+	return slices.Contains(strings.Fields(attr(n, "class")), class)
+}
+
+func (d *pdfDoc) element(n *html.Node) {
+	if id := attr(n, "id"); id != "" {
+		d.pdf.SetLink(d.anchor(id), -1, -1)
+	}
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		d.block()
+		d.pdf.Ln(2)
+		size := d.size
+		d.size = pdfHeadingSizes[n.DataAtom]
+		d.children(n)
+		d.block()
+		d.size = size
+	case atom.P, atom.Div, atom.Nav:
+		if hasClass(n, "math-display") {
+			d.preformatted(textContent(n))
+			return
+		}
+		d.block()
+		d.children(n)
+		d.block()
+	case atom.Br:
+		d.pdf.Ln(d.lineHeight())
+	case atom.Strong, atom.B:
+		d.bold++
+		d.children(n)
+		d.bold--
+	case atom.Em, atom.I:
+		d.italic++
+		d.children(n)
+		d.italic--
+	case atom.Code:
+		d.mono++
+		d.children(n)
+		d.mono--
+	case atom.Span:
+		if hasClass(n, "emoji") {
+			// the fonts have no emoji, so the shortcode stands in
+			d.write(attr(n, "title"))
+			return
+		}
+		if hasClass(n, "math-inline") {
+			d.mono++
+			defer func() { d.mono-- }()
+		}
+		d.children(n)
+	case atom.Pre:
+		d.preformatted(textContent(n))
+	case atom.A:
+		d.href, d.missing = attr(n, "href"), hasClass(n, "missing")
+		d.children(n)
+		d.href, d.missing = "", false
+	case atom.Ul, atom.Ol:
+		next := 0
+		if n.DataAtom == atom.Ol {
+			next = 1
+		}
+		if hasClass(n, "no-bullet") {
+			next = -1
+		}
+		d.block()
+		left := d.leftMargin()
+		d.pdf.SetLeftMargin(left + 6)
+		d.pdf.SetX(left + 6)
+		d.lists = append(d.lists, next)
+		d.children(n)
+		d.lists = d.lists[:len(d.lists)-1]
+		d.block()
+		d.pdf.SetLeftMargin(left)
+		d.pdf.SetX(left)
+	case atom.Li:
+		d.block()
+		if depth := len(d.lists) - 1; depth >= 0 {
+			switch next := d.lists[depth]; {
+			case next > 0:
+				d.write(strconv.Itoa(next) + ". ")
+				d.lists[depth]++
+			case next == 0:
+				d.write("• ")
+			}
+		}
+		d.children(n)
+		d.block()
+	case atom.Table:
+		d.table(n)
+	case atom.Img:
+		d.image(attr(n, "src"), attr(n, "alt"))
+	default:
+		d.children(n)
+	}
+}
+
+// Text runs together, with its whitespace collapsed like a browser would
+func (d *pdfDoc) text(s string) {
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		if s != "" && !d.atLineStart() {
+			d.write(" ")
+		}
+		return
+	}
+	text := strings.Join(words, " ")
+	if strings.TrimLeft(s, " \t\r\n") != s && !d.atLineStart() {
+		text = " " + text
+	}
+	if strings.TrimRight(s, " \t\r\n") != s {
+		text += " "
+	}
+	d.write(text)
+}
+
+// fpdf can't draw characters outside the Basic Multilingual Plane, which is
+// where most emoji live, so they're left out
+func pdfText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r > 0xffff {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func (d *pdfDoc) write(text string) {
+	text = pdfText(text)
+	d.setFont()
+	switch {
+	case strings.HasPrefix(d.href, "#"):
+		d.pdf.WriteLinkID(d.lineHeight(), text, d.anchor(d.href[1:]))
+	case strings.HasPrefix(d.href, "/"):
+		d.pdf.WriteLinkString(d.lineHeight(), text, siteURL()+d.href)
+	case d.href != "":
+		d.pdf.WriteLinkString(d.lineHeight(), text, d.href)
+	default:
+		d.pdf.Write(d.lineHeight(), text)
+	}
+}
+
+// Code, math and diagrams go in a shaded box, line for line
+func (d *pdfDoc) preformatted(text string) {
+	d.block()
+	size := d.size
+	d.size = pdfCodeSize
+	d.mono++
+	d.setFont()
+	d.pdf.SetFillColor(pdfShadeColor[0], pdfShadeColor[1], pdfShadeColor[2])
+	d.pdf.MultiCell(0, d.lineHeight(), pdfText(strings.Trim(text, "\n")), "", "L", true)
+	d.mono--
+	d.size = size
+	d.pdf.Ln(2)
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+type pdfCell struct {
+	text   string
+	header bool
+	align  string
+}
+
+// Tables get equal columns across the page, each row as tall as its fullest cell
+func (d *pdfDoc) table(n *html.Node) {
+	var rows [][]pdfCell
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.DataAtom == atom.Tr {
+			var row []pdfCell
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.DataAtom != atom.Th && c.DataAtom != atom.Td {
+					continue
+				}
+				cell := pdfCell{text: pdfText(strings.Join(strings.Fields(textContent(c)), " ")), header: c.DataAtom == atom.Th, align: "L"}
+				if hasClass(c, "text-center") {
+					cell.align = "C"
+				} else if hasClass(c, "text-right") {
+					cell.align = "R"
+				}
+				row = append(row, cell)
+			}
+			rows = append(rows, row)
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return
+	}
+
+	d.block()
+	size := d.size
+	d.size = pdfTextSize - 1
+	width := d.contentWidth() / float64(columns)
+	_, pageHeight := d.pdf.GetPageSize()
+	_, _, _, bottom := d.pdf.GetMargins()
+	d.pdf.SetDrawColor(pdfBorderColor[0], pdfBorderColor[1], pdfBorderColor[2])
+	d.pdf.SetFillColor(pdfShadeColor[0], pdfShadeColor[1], pdfShadeColor[2])
+	for _, row := range rows {
+		lines := 1
+		for _, cell := range row {
+			d.bold = boolInt(cell.header)
+			d.setFont()
+			lines = max(lines, len(d.pdf.SplitText(cell.text, width-2)))
+		}
+		height := float64(lines)*d.lineHeight() + 2
+		if d.pdf.GetY()+height > pageHeight-bottom {
+			d.pdf.AddPage()
+		}
+		x, y := d.leftMargin(), d.pdf.GetY()
+		for i, cell := range row {
+			style := "D"
+			if cell.header {
+				style = "FD"
+			}
+			d.pdf.Rect(x+float64(i)*width, y, width, height, style)
+			d.bold = boolInt(cell.header)
+			d.setFont()
+			d.pdf.SetXY(x+float64(i)*width, y+1)
+			d.pdf.MultiCell(width, d.lineHeight(), cell.text, "", cell.align, false)
+		}
+		d.pdf.SetXY(x, y+height)
+	}
+	d.bold = 0
+	d.size = size
+	d.pdf.Ln(2)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Embed an attachment image, no wider than the page. Anything that can't be
+// drawn, like a WebP image, comes out as its name.
+func (d *pdfDoc) image(src, alt string) {
+	m := attachmentPath.FindStringSubmatch(withoutBasePath(src))
+	if m == nil {
+		d.italic++
+		d.write(alt)
+		d.italic--
+		return
+	}
+	title, name := m[1], m[2]
+	f, _, err := openAttachment(title, name)
+	if err != nil {
+		d.write(alt)
+		return
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		d.write(alt)
+		return
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "png" && format != "jpeg" && format != "gif") {
+		d.italic++
+		d.write(alt)
+		d.italic--
+		return
+	}
+	d.block()
+	// at the 96 pixels to the inch browsers draw at
+	width := min(float64(config.Width)*25.4/96, d.contentWidth())
+	// attachments needn't be files on disk, so the image is handed over from memory
+	options := fpdf.ImageOptions{ImageType: strings.ToUpper(format)}
+	path := title + "/" + name
+	d.pdf.RegisterImageOptionsReader(path, options, bytes.NewReader(data))
+	d.pdf.ImageOptions(path, d.leftMargin(), -1, width, 0, true, options, 0, "")
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"slices"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// The most results a Postgres search returns
+const pgSearchLimit = 200
+
+// The tables are created when the wiki starts, if they aren't there already.
+// Each page's search vector weights its title above its body, and is kept up
+// to date by Postgres itself.
+const pgSchema = `
+CREATE TABLE IF NOT EXISTS pages (
+	title    text PRIMARY KEY,
+	body     text NOT NULL,
+	modified timestamptz NOT NULL,
+	search   tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', body), 'B')
+	) STORED
+);
+CREATE INDEX IF NOT EXISTS pages_search ON pages USING gin (search);
+CREATE TABLE IF NOT EXISTS revisions (
+	title   text NOT NULL,
+	number  integer NOT NULL,
+	time    timestamptz NOT NULL,
+	author  text NOT NULL DEFAULT '',
+	summary text NOT NULL DEFAULT '',
+	body    text NOT NULL,
+	PRIMARY KEY (title, number)
+);
+`
+
+// pgStore keeps pages and their revisions in PostgreSQL. Each save is one
+// transaction, and search is done by the database's full-text search.
+type pgStore struct {
+	db *sql.DB
+}
+
+func openPostgresStore() (*pgStore, error) {
+	if config.DatabaseURL == "" {
+		return nil, errors.New("-database-url is needed to use postgres storage")
+	}
+	// everything besides pages is still kept in the data directory
+	if err := os.MkdirAll(config.DataDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", config.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(pgSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &pgStore{db: db}, nil
+}
+
+func (s *pgStore) List() ([]string, error) {
+	rows, err := s.db.Query(`SELECT title FROM pages`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var titles []string
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return nil, err
+		}
+		titles = append(titles, title)
+	}
+	slices.SortFunc(titles, compareTitles)
+	return titles, rows.Err()
+}
+
+func (s *pgStore) Exists(title string) bool {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pages WHERE title = $1)`, title).Scan(&exists)
+	return err == nil && exists
+}
+
+// Missing pages and revisions are os.ErrNotExist, the same as missing files
+func pgNotExist(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+func (s *pgStore) Load(ctx context.Context, title string) (*Page, error) {
+	var body string
+	if err := s.db.QueryRowContext(ctx, `SELECT body FROM pages WHERE title = $1`, title).Scan(&body); err != nil {
+		return nil, pgNotExist(err)
+	}
+	return &Page{Title: title, Body: []byte(body)}, nil
+}
+
+func (s *pgStore) Save(ctx context.Context, p *Page, edit Edit) (*Revision, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// concurrent saves of the same page take turns, so each gets its own number
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, p.Title); err != nil {
+		return nil, err
+	}
+	var current string
+	if err := tx.QueryRowContext(ctx, `SELECT body FROM pages WHERE title = $1`, p.Title).Scan(&current); err == nil && current == string(p.Body) {
+		return nil, errUnchanged
+	}
+	rev := &Revision{Time: time.Now().UTC(), Author: edit.Author, Summary: edit.Summary}
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(number), 0) + 1 FROM revisions WHERE title = $1`, p.Title).Scan(&rev.Number)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO revisions (title, number, time, author, summary, body) VALUES ($1, $2, $3, $4, $5, $6)`,
+		p.Title, rev.Number, rev.Time, rev.Author, rev.Summary, string(p.Body))
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO pages (title, body, modified) VALUES ($1, $2, $3)
+		ON CONFLICT (title) DO UPDATE SET body = EXCLUDED.body, modified = EXCLUDED.modified`,
+		p.Title, string(p.Body), rev.Time)
+	if err != nil {
+		return nil, err
+	}
+	return rev, tx.Commit()
+}
+
+func (s *pgStore) Delete(ctx context.Context, title string, edit Edit) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM pages WHERE title = $1`, title)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+func (s *pgStore) ModTime(title string) (time.Time, error) {
+	var modified time.Time
+	err := s.db.QueryRow(`SELECT modified FROM pages WHERE title = $1`, title).Scan(&modified)
+	return modified, pgNotExist(err)
+}
+
+func (s *pgStore) Revisions(title string) ([]Revision, error) {
+	rows, err := s.db.Query(`SELECT number, time, author, summary FROM revisions WHERE title = $1 ORDER BY number`, title)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var revs []Revision
+	for rows.Next() {
+		var rev Revision
+		if err := rows.Scan(&rev.Number, &rev.Time, &rev.Author, &rev.Summary); err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+	return revs, rows.Err()
+}
+
+func (s *pgStore) LoadRevision(title string, number int) ([]byte, error) {
+	var body string
+	err := s.db.QueryRow(`SELECT body FROM revisions WHERE title = $1 AND number = $2`, title, number).Scan(&body)
+	if err != nil {
+		return nil, pgNotExist(err)
+	}
+	return []byte(body), nil
+}
+
+func (s *pgStore) PurgeHistory(title string) error {
+	_, err := s.db.Exec(`DELETE FROM revisions WHERE title = $1`, title)
+	return err
+}
+
+// The room the tables take, indexes included
+func (s *pgStore) Usage(ctx context.Context) (storageUsage, error) {
+	var u storageUsage
+	err := s.db.QueryRowContext(ctx, `SELECT pg_total_relation_size('pages'), pg_total_relation_size('revisions')`).Scan(&u.Pages, &u.History)
+	return u, err
+}
+
+// Search with the pages' full-text index, best match first. Queries can use
+// quotes for phrases, "or" and a leading - to leave a word out.
+func (s *pgStore) Search(query string) ([]*Page, error) {
+	rows, err := s.db.Query(`SELECT title, body FROM pages, websearch_to_tsquery('english', $1) query
+		WHERE search @@ query ORDER BY ts_rank(search, query) DESC, title LIMIT $2`, query, pgSearchLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pages []*Page
+	for rows.Next() {
+		var title, body string
+		if err := rows.Scan(&title, &body); err != nil {
+			return nil, err
+		}
+		pages = append(pages, &Page{Title: title, Body: []byte(body)})
+	}
+	return pages, rows.Err()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+)
+
+var placeholderMarker = regexp.MustCompile("\x00([0-9]+)\x00")
+
+// placeholders stand in for finished HTML while the rest of a page is
+// rendered, so no other markup rule touches what's inside. Escaping turns
+// any NUL in a page into U+FFFD, so the markers can't be forged.
+type placeholders [][]byte
+
+// Keep the HTML and return the marker standing in for it
+func (p *placeholders) mark(html string) []byte {
+	*p = append(*p, []byte(html))
+	return []byte("\x00" + strconv.Itoa(len(*p)-1) + "\x00")
+}
+
+// Put the HTML back where its markers are
+func (p placeholders) restore(out []byte) []byte {
+	if len(p) == 0 {
+		return out
+	}
+	return placeholderMarker.ReplaceAllFunc(out, func(m []byte) []byte {
+		i, _ := strconv.Atoi(string(placeholderMarker.FindSubmatch(m)[1]))
+		if i < len(p) {
+			return p[i]
+		}
+		return nil
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// presence tracks who has each page open in the editor, counted by their
+// live preview connections, so editors can be warned about each other
+type presence struct {
+	mu      sync.Mutex
+	editors map[string]map[string]int
+}
+
+var editing = &presence{editors: make(map[string]map[string]int)}
+
+func (p *presence) join(title, user string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.editors[title] == nil {
+		p.editors[title] = make(map[string]int)
+	}
+	p.editors[title][user]++
+}
+
+func (p *presence) leave(title, user string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.editors[title][user]--; p.editors[title][user] <= 0 {
+		delete(p.editors[title], user)
+	}
+	if len(p.editors[title]) == 0 {
+		delete(p.editors, title)
+	}
+}
+
+// Who else is editing a page, in name order
+func (p *presence) others(title, user string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var names []string
+	for name := range p.editors[title] {
+		if name != user {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
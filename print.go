@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"os"
+)
+
+// /print/<title> shows just the page, without the navigation, sidebar and
+// buttons around it, laid out for paper: the print rules in static/wiki.css
+// keep headings with what follows them and don't split code or tables
+// across pages. Printing the view page itself drops the same clutter.
+func printHandler(w http.ResponseWriter, r *http.Request, title string) {
+	p, err := loadPage(r.Context(), title)
+	if errors.Is(err, os.ErrNotExist) {
+		notFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "print", struct {
+		*Page
+		HTML template.HTML
+		URL  string
+	}{p, p.HTML(requestLocale(r)), siteURL() + pageURL("view", title)})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Behind a reverse proxy every request seems to come from the proxy, over
+// plain HTTP. Proxies listed in -trusted-proxies pass on the real client and
+// scheme in X-Forwarded-For and X-Forwarded-Proto; from anywhere else those
+// headers are ignored, since anyone can send them.
+var (
+	trustedProxies []netip.Prefix
+	trustUnixProxy bool
+)
+
+// Parse -trusted-proxies, each an address, a range or unix
+func checkTrustedProxies() error {
+	trustedProxies, trustUnixProxy = nil, false
+	for _, s := range config.TrustedProxies {
+		if s == "unix" {
+			trustUnixProxy = true
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return fmt.Errorf("-trusted-proxies takes addresses, ranges such as 10.0.0.0/8 and unix, not %q", s)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+	return nil
+}
+
+func trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// The address the request came in from, before any proxy is taken into account
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Did the request come through one of the trusted proxies?
+func fromTrustedProxy(r *http.Request) bool {
+	if overUnixSocket(r) {
+		return trustUnixProxy
+	}
+	return len(trustedProxies) > 0 && trustedProxy(peerIP(r))
+}
+
+// The client a trusted proxy forwarded the request for. X-Forwarded-For is
+// read from the right, past any more trusted proxies, since whatever is left
+// of the first one we don't trust could have been made up by the client.
+func forwardedFor(r *http.Request) string {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if _, err := netip.ParseAddr(hops[i]); err != nil {
+			return ""
+		}
+		if i == 0 || !trustedProxy(hops[i]) {
+			return hops[i]
+		}
+	}
+	return ""
+}
+
+// Did the request reach us, or the proxy in front of us, over HTTPS?
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if !fromTrustedProxy(r) {
+		return false
+	}
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+func requestScheme(r *http.Request) string {
+	if isHTTPS(r) {
+		return "https"
+	}
+	return "http"
+}
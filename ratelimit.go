@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter hands out a token bucket per client: each holds up to burst
+// tokens and refills at perMinute a minute
+type rateLimiter struct {
+	mu        sync.Mutex
+	perMinute int
+	burst     int
+	buckets   map[string]*bucket
+	pruned    time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var writeLimiter = newRateLimiter(30, 10)
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{perMinute: perMinute, burst: burst, buckets: make(map[string]*bucket)}
+}
+
+// Take a token for the client, or say how long until one is free
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	if l.perMinute <= 0 {
+		return true, 0
+	}
+	now := time.Now()
+	perSecond := float64(l.perMinute) / 60
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now, perSecond)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Forget clients whose buckets have filled up again, once a minute
+func (l *rateLimiter) prune(now time.Time, perSecond float64) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*perSecond >= float64(l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Logged in users are limited by name, wherever they connect from; everyone
+// else by address
+func rateLimitKey(r *http.Request) string {
+	if user := username(r); user != "" {
+		return "user:" + user
+	}
+	return "ip:" + clientIP(r)
+}
+
+// Check the write limit for a request, setting Retry-After when it's used up
+func allowWrite(w http.ResponseWriter, r *http.Request) bool {
+	ok, wait := writeLimiter.allow(rateLimitKey(r))
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+	return ok
+}
+
+// Refuse writes from clients going faster than the limit allows
+func rateLimitWrites(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// only changes count; looking at forms is free
+		if r.Method == http.MethodPost && !allowWrite(w, r) {
+			httpError(w, r, http.StatusTooManyRequests, "You're making changes too quickly. Wait a little and try again.")
+			return
+		}
+		fn(w, r)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+)
+
+// /raw/<title> sends the page's source as plain text, for scripts, spell
+// checkers and curl. An older revision can be fetched with ?rev=N.
+func rawHandler(w http.ResponseWriter, r *http.Request, title string) {
+	var body []byte
+	if rev := r.FormValue("rev"); rev != "" {
+		number, err := strconv.Atoi(rev)
+		if err != nil || number < 1 {
+			httpError(w, r, http.StatusBadRequest, "The revision must be a number.")
+			return
+		}
+		if body, err = store.LoadRevision(title, number); err != nil {
+			notFound(w, r)
+			return
+		}
+	} else {
+		p, err := loadPage(r.Context(), title)
+		if err != nil {
+			notFound(w, r)
+			return
+		}
+		body = p.Body
+	}
+	sum := sha256.Sum256(body)
+	if checkNotModified(w, r, `"`+hex.EncodeToString(sum[:16])+`"`) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(body)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// In read-only mode nothing can change pages, for maintenance windows and
+// public mirrors. It starts from the config and admins can toggle it at
+// runtime, which is saved with the rest of the settings from /admin.
+var readOnly atomic.Bool
+
+// Refuse requests that would change the wiki while it's read-only, or that
+// come from a blocked user or address
+func requireWritable(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if readOnly.Load() {
+			w.WriteHeader(http.StatusForbidden)
+			renderTemplate(w, r, "readonly", nil)
+			return
+		}
+		if refuseBlocked(w, r) {
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// GET shows whether the wiki is read-only, POST switches it on or off
+func readOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		on := r.FormValue("read_only") == "on"
+		if err := saveSettings(func(s *siteSettings) { s.ReadOnly = &on }); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		audit(r, "read-only", "", r.FormValue("read_only"))
+		backToAdmin(w, r, "/admin/readonly")
+		return
+	}
+	renderTemplate(w, r, "readonly", struct{ Admin, ReadOnly bool }{true, readOnly.Load()})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// How many requests have panicked since the wiki started, published at /debug/vars
+var handlerPanics = expvar.NewInt("handler_panics")
+
+// recovery middleware: a handler that panics gets the usual 500 page,
+// with the stack in the log under the request's ID, rather than taking the
+// connection down with it. If the response was already under way it's too
+// late for the page, so the connection is just closed.
+func recoverHandler(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// handlers abort responses on purpose with this one
+			if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(v)
+			}
+			handlerPanics.Add(1)
+			id := requestID(r)
+			if id == "" {
+				id = newRequestID()
+			}
+			log.Printf("Request %s for %s panicked: %v\n%s", id, r.URL.Path, v, debug.Stack())
+			if rec.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			// whatever the handler meant to send no longer applies
+			for name := range w.Header() {
+				if name != "X-Request-Id" {
+					w.Header().Del(name)
+				}
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusInternalServerError)
+			renderTemplate(w, r, "error", errorData{
+				Status:     http.StatusInternalServerError,
+				StatusText: http.StatusText(http.StatusInternalServerError),
+				RequestID:  id,
+			})
+		}()
+		h.ServeHTTP(rec, r)
+	}
+	return http.HandlerFunc(fn)
+}
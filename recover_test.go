@@ -0,0 +1,49 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A panic gets the 500 page even when the response is compressed, as it is
+// for nearly every browser
+func TestRecoverCompressed(t *testing.T) {
+	if err := loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	// the stack the panic logs is expected here
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+	h := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/view/Home", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want 500", w.Code)
+	}
+	var body io.Reader = w.Body
+	if w.Header().Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = zr
+	}
+	page, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := w.Header().Get("X-Request-Id")
+	if id == "" || !strings.Contains(string(page), id) {
+		t.Errorf("the error page doesn't give the request ID %q:\n%s", id, page)
+	}
+}
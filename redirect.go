@@ -0,0 +1,82 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// How many redirects in a row are followed before giving up
+const maxRedirects = 5
+
+var redirectLine = regexp.MustCompile(`(?i)^\s*#REDIRECT\s*\[\[([^\[\]]+)\]\]`)
+
+// The page a body redirects to, or empty if it isn't a redirect
+func redirectTarget(body []byte) string {
+	m := redirectLine.FindSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	if target := strings.TrimSpace(string(m[1])); validTitle(target) {
+		return target
+	}
+	return ""
+}
+
+// redirectIndex records which pages are redirects and where to, so views
+// can follow a chain of them without loading every page along the way
+type redirectIndex struct {
+	mu      sync.RWMutex
+	targets map[string]string
+}
+
+var redirects = &redirectIndex{targets: make(map[string]string)}
+
+func (ri *redirectIndex) update(title string, body []byte) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	if target := redirectTarget(body); target != "" {
+		ri.targets[title] = target
+	} else {
+		delete(ri.targets, title)
+	}
+}
+
+func (ri *redirectIndex) remove(title string) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	delete(ri.targets, title)
+}
+
+func (ri *redirectIndex) target(title string) string {
+	ri.mu.RLock()
+	defer ri.mu.RUnlock()
+	return ri.targets[title]
+}
+
+// Follow redirects from title to the page they end at. It's false if they go
+// round in a circle, run on too long, or end at a page that doesn't exist.
+func resolveRedirect(title string) (string, bool) {
+	seen := map[string]bool{title: true}
+	for hops := 0; ; hops++ {
+		target := redirects.target(title)
+		if target == "" {
+			return title, pageExists(title)
+		}
+		target = resolveTitle(target)
+		if seen[target] || hops == maxRedirects {
+			return "", false
+		}
+		seen[target] = true
+		title = target
+	}
+}
+
+// Redirect is where the page redirects to, for marking redirects on the contents
+func (t titlePart) Redirect() string {
+	return redirects.target(t.Title)
+}
+
+func (m modifiedPage) Redirect() string {
+	return redirects.target(m.Title)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Session keys in Redis start with this, so the database can be shared with other things
+const redisSessionPrefix = "gowiki:session:"
+
+// redisSessions keeps sessions in Redis, where they expire by themselves.
+// Every wiki server pointed at the same Redis sees the same logins.
+type redisSessions struct {
+	client *redis.Client
+}
+
+func openRedisSessions() (*redisSessions, error) {
+	if config.RedisURL == "" {
+		return nil, errors.New("-redis-url is needed to keep sessions in Redis")
+	}
+	opts, err := redis.ParseURL(config.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &redisSessions{client: client}, nil
+}
+
+func (s *redisSessions) Create(username string) (string, session, error) {
+	id, sess, err := newSession(username)
+	if err != nil {
+		return "", session{}, err
+	}
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return "", session{}, err
+	}
+	if err := s.client.Set(context.Background(), redisSessionPrefix+id, data, sessionLifetime).Err(); err != nil {
+		return "", session{}, err
+	}
+	return id, sess, nil
+}
+
+// If Redis can't be reached, visitors are treated as logged out until it's back
+func (s *redisSessions) Get(id string) (session, bool) {
+	data, err := s.client.Get(context.Background(), redisSessionPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return session{}, false
+	}
+	if err != nil {
+		log.Printf("Couldn't look up session in Redis: %s\n", err.Error())
+		return session{}, false
+	}
+	var sess session
+	if err := json.Unmarshal(data, &sess); err != nil || time.Now().After(sess.Expires) {
+		return session{}, false
+	}
+	return sess, true
+}
+
+func (s *redisSessions) Delete(id string) {
+	if err := s.client.Del(context.Background(), redisSessionPrefix+id).Err(); err != nil {
+		log.Printf("Couldn't end session in Redis: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// discardWriter is a ResponseWriter that throws the page away, so only the
+// rendering's own allocations are counted
+type discardWriter struct{ header http.Header }
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+// A view page of a few kilobytes, as most pages are
+func benchmarkViewData() viewData {
+	body := strings.Repeat("<p>Some of the page, with a <a href=\"/view/Other\">link</a> in it.</p>\n", 80)
+	p := &Page{Title: "Benchmark", Body: []byte(body), ModTime: time.Now(), LastEditor: "alice"}
+	return viewData{
+		Page:        p,
+		HTML:        template.HTML(body),
+		Breadcrumbs: []titlePart{{Name: "Benchmark", Title: "Benchmark"}},
+		Backlinks:   []string{"Home", "Other"},
+		Views:       42,
+	}
+}
+
+func setupBenchmarkTemplates(b *testing.B) {
+	b.Helper()
+	if err := loadTemplates(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// Rendering through the pool of buffers, as every handler does
+func BenchmarkRenderTemplate(b *testing.B) {
+	setupBenchmarkTemplates(b)
+	data := benchmarkViewData()
+	w := &discardWriter{header: http.Header{}}
+	r := httptest.NewRequest(http.MethodGet, "/view/Benchmark", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		renderTemplate(w, r, "view", data)
+	}
+}
+
+// Rendering into a new buffer every time, to compare the pool against
+func BenchmarkRenderTemplateUnpooled(b *testing.B) {
+	setupBenchmarkTemplates(b)
+	data := benchmarkViewData()
+	w := &discardWriter{header: http.Header{}}
+	t, err := currentTemplates(locales[config.Language], siteZone)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(&buf, "view.html", data); err != nil {
+			b.Fatal(err)
+		}
+		w.Write(buf.Bytes())
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"expvar"
+	"html/template"
+	"sync"
+)
+
+// Hit and miss counts for the render cache, published at /debug/vars
+var (
+	renderCacheHits   = expvar.NewInt("render_cache_hits")
+	renderCacheMisses = expvar.NewInt("render_cache_misses")
+)
+
+// renderCache keeps the most recently used renderings of pages. Entries are
+// keyed by the page's content as well as its title, so each revision has its
+// own, and by language, for the table of contents and include notices. A
+// page's entries are dropped when it or a page it links to changes.
+type renderCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // most recently used at the front
+	entries map[renderKey]*list.Element
+}
+
+type renderKey struct {
+	title string
+	lang  string
+	body  [sha256.Size]byte
+}
+
+type renderEntry struct {
+	key  renderKey
+	html template.HTML
+}
+
+var renders = newRenderCache(1000)
+
+func newRenderCache(size int) *renderCache {
+	return &renderCache{size: size, order: list.New(), entries: make(map[renderKey]*list.Element)}
+}
+
+// Render a page body, from the cache if it's been rendered before
+func (c *renderCache) render(l *locale, title string, body []byte) template.HTML {
+	if c.size <= 0 {
+		return renderMarkup(l, title, body)
+	}
+	key := renderKey{title, l.Code, sha256.Sum256(body)}
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		renderCacheHits.Add(1)
+		return e.Value.(*renderEntry).html
+	}
+	c.mu.Unlock()
+
+	renderCacheMisses.Add(1)
+	html := renderMarkup(l, title, body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.order.PushFront(&renderEntry{key, html})
+		for c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*renderEntry).key)
+		}
+	}
+	return html
+}
+
+// Forget the renderings of the given pages
+func (c *renderCache) invalidate(titles ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	drop := make(map[string]bool, len(titles))
+	for _, title := range titles {
+		drop[title] = true
+	}
+	for key, e := range c.entries {
+		if drop[key.title] {
+			c.order.Remove(e)
+			delete(c.entries, key)
+		}
+	}
+}
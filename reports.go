@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+)
+
+// A wanted page: linked to, but not written yet
+type wantedPage struct {
+	Title      string
+	LinkedFrom []string
+}
+
+// Pages nothing else links to, sorted
+func (g *linkGraph) orphans() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	linked := make(map[string]bool)
+	for source, targets := range g.links {
+		for _, target := range targets {
+			if target = resolveTitle(target); target != source {
+				linked[target] = true
+			}
+		}
+	}
+	var titles []string
+	for title := range g.links {
+		if !linked[title] {
+			titles = append(titles, title)
+		}
+	}
+	sort.Slice(titles, func(i, j int) bool { return compareTitles(titles[i], titles[j]) < 0 })
+	return titles
+}
+
+// Pages that link nowhere but themselves, sorted
+func (g *linkGraph) deadEnds() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var titles []string
+	for title, targets := range g.links {
+		if !slices.ContainsFunc(targets, func(target string) bool { return resolveTitle(target) != title }) {
+			titles = append(titles, title)
+		}
+	}
+	sort.Slice(titles, func(i, j int) bool { return compareTitles(titles[i], titles[j]) < 0 })
+	return titles
+}
+
+// Link targets with no page, with the pages linking to each
+func (g *linkGraph) wanted() map[string][]string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	wanted := make(map[string][]string)
+	for source, targets := range g.links {
+		for _, target := range targets {
+			if _, ok := titleCases.resolve(target); !ok {
+				wanted[target] = append(wanted[target], source)
+			}
+		}
+	}
+	return wanted
+}
+
+var reports = map[string]string{
+	"orphans":   "Orphaned pages",
+	"dead-ends": "Dead-end pages",
+	"wanted":    "Wanted pages",
+}
+
+// /reports lists the reports, and /reports/<name> shows one. Only pages
+// the visitor can read are listed, though the graph behind them covers every page.
+func reportsHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[len("/reports"):]
+	data := struct {
+		Report  string
+		Heading string
+		Titles  []string
+		Wanted  []wantedPage
+	}{}
+	if name == "" || name == "/" {
+		renderTemplate(w, r, "reports", data)
+		return
+	}
+	name = name[1:]
+	heading, ok := reports[name]
+	if !ok {
+		notFound(w, r)
+		return
+	}
+	data.Report, data.Heading = name, heading
+	switch name {
+	case "orphans":
+		data.Titles = readableTitles(r, links.orphans())
+	case "dead-ends":
+		data.Titles = readableTitles(r, links.deadEnds())
+	case "wanted":
+		for target, sources := range links.wanted() {
+			if sources = readableTitles(r, sources); len(sources) > 0 {
+				sort.Slice(sources, func(i, j int) bool { return compareTitles(sources[i], sources[j]) < 0 })
+				data.Wanted = append(data.Wanted, wantedPage{Title: target, LinkedFrom: sources})
+			}
+		}
+		// the most wanted first
+		sort.Slice(data.Wanted, func(i, j int) bool {
+			a, b := data.Wanted[i], data.Wanted[j]
+			if len(a.LinkedFrom) != len(b.LinkedFrom) {
+				return len(a.LinkedFrom) > len(b.LinkedFrom)
+			}
+			return compareTitles(a.Title, b.Title) < 0
+		})
+	}
+	renderTemplate(w, r, "reports", data)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+// IDs passed on by a proxy are only taken if they look like IDs, so nothing
+// odd ends up in the logs
+var validRequestID = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// request ID middleware: gives every request an ID, which is logged with it,
+// shown on error pages and sent back in X-Request-ID, so a visitor's report
+// can be matched to the log. A trusted proxy's own X-Request-ID is kept, so
+// its logs and ours agree.
+func requestIDHandler(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !fromTrustedProxy(r) || !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// The request's ID, or "" outside the middleware
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Client talks to S3, or anything speaking its API such as MinIO, using
+// path-style URLs and version 4 signatures. It only does the little gowiki needs.
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	http      *http.Client
+}
+
+// An object in a listing
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// Missing objects are os.ErrNotExist, the same as missing files
+var errS3NotFound = fmt.Errorf("no such object: %w", os.ErrNotExist)
+
+// A put that was only to make new objects found one there already
+var errS3Exists = fmt.Errorf("object already exists: %w", os.ErrExist)
+
+func newS3Client() (*s3Client, error) {
+	if config.S3Bucket == "" {
+		return nil, errors.New("-s3-bucket is needed to use S3")
+	}
+	endpoint, err := url.Parse(config.S3Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("the S3 endpoint %q must be an http or https URL", config.S3Endpoint)
+	}
+	return &s3Client{endpoint: endpoint, region: config.S3Region, bucket: config.S3Bucket,
+		accessKey: config.S3AccessKey, secretKey: config.S3SecretKey, http: &http.Client{Timeout: 5 * time.Minute}}, nil
+}
+
+// Escape a path the way signatures expect: everything but unreserved characters and slashes
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Make a signed request for an object, or the bucket itself when key is empty,
+// with any extra headers, such as conditions, left out of the signature.
+// Bodies aren't hashed, which S3 allows as UNSIGNED-PAYLOAD.
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	path := "/" + s3Escape(c.bucket, false)
+	if key != "" {
+		path += "/" + s3Escape(key, true)
+	}
+	var params []string
+	for name, values := range query {
+		for _, v := range values {
+			params = append(params, s3Escape(name, false)+"="+s3Escape(v, false))
+		}
+	}
+	sort.Strings(params)
+	rawQuery := strings.Join(params, "&")
+
+	path = strings.TrimSuffix(c.endpoint.EscapedPath(), "/") + path
+	target := c.endpoint.Scheme + "://" + c.endpoint.Host + path
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	const payload = "UNSIGNED-PAYLOAD"
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	canonical := strings.Join([]string{
+		method,
+		path,
+		rawQuery,
+		"host:" + c.endpoint.Host + "\nx-amz-content-sha256:" + payload + "\nx-amz-date:" + amzDate + "\n",
+		"host;x-amz-content-sha256;x-amz-date",
+		payload,
+	}, "\n")
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	signingKey := hmacSHA256(hmacSHA256(hmacSHA256(hmacSHA256([]byte("AWS4"+c.secretKey), day), c.region), "s3"), "aws4_request")
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.accessKey+"/"+scope+
+		", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="+hex.EncodeToString(hmacSHA256(signingKey, toSign)))
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, errS3NotFound
+	case http.StatusPreconditionFailed:
+		return nil, errS3Exists
+	}
+	// S3 explains itself in an XML error document
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+		return nil, fmt.Errorf("S3 %s %s: %s: %s", method, key, s3Err.Code, s3Err.Message)
+	}
+	return nil, fmt.Errorf("S3 %s %s: %s", method, key, resp.Status)
+}
+
+func (c *s3Client) put(ctx context.Context, key string, body io.Reader, size int64) error {
+	resp, err := c.do(ctx, http.MethodPut, key, nil, nil, body, size)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *s3Client) putBytes(ctx context.Context, key string, data []byte) error {
+	return c.put(ctx, key, bytes.NewReader(data), int64(len(data)))
+}
+
+// Put an object only if there isn't one at the key yet, else errS3Exists
+func (c *s3Client) putNew(ctx context.Context, key string, data []byte) error {
+	resp, err := c.do(ctx, http.MethodPut, key, nil, http.Header{"If-None-Match": {"*"}}, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Open an object for reading; the caller closes it
+func (c *s3Client) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Fetch a whole object, along with when it was last modified
+func (c *s3Client) getBytes(ctx context.Context, key string) ([]byte, time.Time, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return data, modTime, err
+}
+
+// When an object was last modified, or errS3NotFound if there's no such object
+func (c *s3Client) head(ctx context.Context, key string) (time.Time, error) {
+	resp, err := c.do(ctx, http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return modTime, nil
+}
+
+func (c *s3Client) remove(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, nil, nil, nil, 0)
+	if errors.Is(err, errS3NotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Every object whose key starts with prefix, in key order, a page of results at a time
+func (c *s3Client) list(ctx context.Context, prefix string) ([]s3Object, error) {
+	var objects []s3Object
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := c.do(ctx, http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents              []s3Object `xml:"Contents"`
+			IsTruncated           bool       `xml:"IsTruncated"`
+			NextContinuationToken string     `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Store keeps pages in an S3 bucket, so the wiki's pages and attachments
+// needn't live on the machine serving them. Under the configured prefix, pages
+// are pages/<title>.txt, revisions history/<title>/<n>.txt with their details
+// in <n>.json, and attachments attachments/<title>/<name>.
+type s3Store struct {
+	client *s3Client
+	prefix string
+}
+
+func openS3Store() (*s3Store, error) {
+	// everything besides pages and attachments is still kept in the data directory
+	if err := os.MkdirAll(config.DataDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	client, err := newS3Client()
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(config.S3Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &s3Store{client: client, prefix: prefix}, nil
+}
+
+func (s *s3Store) pageKey(title string) string {
+	return s.prefix + "pages/" + titlePath(title) + ".txt"
+}
+
+func (s *s3Store) historyKey(title string) string {
+	return s.prefix + "history/" + titleFileName(title) + "/"
+}
+
+func (s *s3Store) List() ([]string, error) {
+	prefix := s.prefix + "pages/"
+	objects, err := s.client.list(context.Background(), prefix)
+	if err != nil {
+		return nil, err
+	}
+	var titles []string
+	for _, o := range objects {
+		name, ok := strings.CutSuffix(strings.TrimPrefix(o.Key, prefix), ".txt")
+		if !ok {
+			continue
+		}
+		if title, ok := titleFromFileName(name); ok {
+			titles = append(titles, title)
+		}
+	}
+	slices.SortFunc(titles, compareTitles)
+	return titles, nil
+}
+
+func (s *s3Store) Exists(title string) bool {
+	_, err := s.client.head(context.Background(), s.pageKey(title))
+	return err == nil
+}
+
+func (s *s3Store) Load(ctx context.Context, title string) (*Page, error) {
+	body, _, err := s.client.getBytes(ctx, s.pageKey(title))
+	if err != nil {
+		return nil, err
+	}
+	return &Page{Title: title, Body: body}, nil
+}
+
+// Saves of a page take turns, as the file store's do. Other servers sharing
+// the bucket don't wait, so a revision's details are only written if no one
+// has taken its number yet, and if someone has, the next number is tried.
+func (s *s3Store) Save(ctx context.Context, p *Page, edit Edit) (*Revision, error) {
+	unlock, err := lockPage(p.Title)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if current, _, err := s.client.getBytes(ctx, s.pageKey(p.Title)); err == nil && bytes.Equal(current, p.Body) {
+		return nil, errUnchanged
+	}
+	rev := &Revision{Time: time.Now().UTC(), Author: edit.Author, Summary: edit.Summary}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		last, err := s.lastRevision(ctx, p.Title)
+		if err != nil {
+			return nil, err
+		}
+		rev.Number = last + 1
+		meta, err := json.Marshal(rev)
+		if err != nil {
+			return nil, err
+		}
+		err = s.client.putNew(ctx, s.historyKey(p.Title)+strconv.Itoa(rev.Number)+".json", meta)
+		if errors.Is(err, errS3Exists) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+	if err := s.client.putBytes(ctx, s.historyKey(p.Title)+strconv.Itoa(rev.Number)+".txt", p.Body); err != nil {
+		return nil, err
+	}
+	return rev, s.client.putBytes(ctx, s.pageKey(p.Title), p.Body)
+}
+
+// The number of a page's latest revision, or 0 if it has none, going by the
+// names in the listing rather than fetching each revision's details
+func (s *s3Store) lastRevision(ctx context.Context, title string) (int, error) {
+	prefix := s.historyKey(title)
+	objects, err := s.client.list(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	last := 0
+	for _, o := range objects {
+		name, ok := strings.CutSuffix(strings.TrimPrefix(o.Key, prefix), ".json")
+		if !ok {
+			continue
+		}
+		if number, err := strconv.Atoi(name); err == nil {
+			last = max(last, number)
+		}
+	}
+	return last, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, title string, edit Edit) error {
+	// deleting an object that isn't there succeeds, but deleting a missing page mustn't
+	if _, err := s.client.head(ctx, s.pageKey(title)); err != nil {
+		return err
+	}
+	return s.client.remove(ctx, s.pageKey(title))
+}
+
+func (s *s3Store) ModTime(title string) (time.Time, error) {
+	return s.client.head(context.Background(), s.pageKey(title))
+}
+
+// A page's revisions, oldest first, read from their .json files
+func (s *s3Store) Revisions(title string) ([]Revision, error) {
+	prefix := s.historyKey(title)
+	objects, err := s.client.list(context.Background(), prefix)
+	if err != nil {
+		return nil, err
+	}
+	var revs []Revision
+	for _, o := range objects {
+		name, ok := strings.CutSuffix(strings.TrimPrefix(o.Key, prefix), ".json")
+		if !ok {
+			continue
+		}
+		number, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		data, _, err := s.client.getBytes(context.Background(), o.Key)
+		if err != nil {
+			return nil, err
+		}
+		rev := Revision{Number: number}
+		if err := json.Unmarshal(data, &rev); err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+	slices.SortFunc(revs, func(a, b Revision) int { return a.Number - b.Number })
+	return revs, nil
+}
+
+func (s *s3Store) LoadRevision(title string, number int) ([]byte, error) {
+	body, _, err := s.client.getBytes(context.Background(), s.historyKey(title)+strconv.Itoa(number)+".txt")
+	return body, err
+}
+
+func (s *s3Store) PurgeHistory(title string) error {
+	objects, err := s.client.list(context.Background(), s.historyKey(title))
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if err := s.client.remove(context.Background(), o.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The size of everything under the prefix, by what it's for
+func (s *s3Store) Usage(ctx context.Context) (storageUsage, error) {
+	var u storageUsage
+	objects, err := s.client.list(ctx, s.prefix)
+	if err != nil {
+		return u, err
+	}
+	for _, o := range objects {
+		top, _, _ := strings.Cut(strings.TrimPrefix(o.Key, s.prefix), "/")
+		switch top {
+		case "pages":
+			u.Pages += o.Size
+		case "history":
+			u.History += o.Size
+		case "attachments":
+			u.Attachments += o.Size
+		default:
+			u.Other += o.Size
+		}
+	}
+	return u, nil
+}
+
+// s3Attachments keeps attachments in the same bucket as the pages
+type s3Attachments struct {
+	*s3Store
+}
+
+func (s s3Attachments) key(title string) string {
+	return s.prefix + "attachments/" + titleFileName(title) + "/"
+}
+
+func (s s3Attachments) list(title string) ([]string, error) {
+	prefix := s.key(title)
+	objects, err := s.client.list(context.Background(), prefix)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, o := range objects {
+		if name := strings.TrimPrefix(o.Key, prefix); attachmentName.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Uploads are small enough to hold in memory, and S3 needs to know the size up front
+func (s s3Attachments) save(title, name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return s.client.putBytes(context.Background(), s.key(title)+name, data)
+}
+
+func (s s3Attachments) open(title, name string) (io.ReadSeekCloser, time.Time, error) {
+	data, modTime, err := s.client.getBytes(context.Background(), s.key(title)+name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return memoryFile{bytes.NewReader(data)}, modTime, nil
+}
+
+// memoryFile lets an attachment held in memory be served like one on disk
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error {
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// How many bytes of context to show either side of the first match
+const snippetContext = 80
+
+type searchResult struct {
+	Title   string
+	Score   int
+	Snippet template.HTML
+}
+
+// wordIndex records the words on each page, split at whitespace, so a search
+// only has to read the pages with a word matching one of its terms. Terms
+// never hold whitespace, so a term is in a page exactly when it's in one of
+// the page's words.
+type wordIndex struct {
+	mu    sync.RWMutex
+	pages map[string][]string        // the distinct words on each page
+	words map[string]map[string]bool // the pages each word is on
+}
+
+var words = &wordIndex{pages: make(map[string][]string), words: make(map[string]map[string]bool)}
+
+func (w *wordIndex) update(title string, body []byte) {
+	var found []string
+	seen := make(map[string]bool)
+	for _, word := range strings.Fields(string(body)) {
+		if !seen[word] {
+			seen[word] = true
+			found = append(found, word)
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.forget(title)
+	w.pages[title] = found
+	for _, word := range found {
+		if w.words[word] == nil {
+			w.words[word] = make(map[string]bool)
+		}
+		w.words[word][title] = true
+	}
+}
+
+func (w *wordIndex) remove(title string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.forget(title)
+}
+
+// Take a page's words out of the index; callers must hold the lock
+func (w *wordIndex) forget(title string) {
+	for _, word := range w.pages[title] {
+		delete(w.words[word], title)
+		if len(w.words[word]) == 0 {
+			delete(w.words, word)
+		}
+	}
+	delete(w.pages, title)
+}
+
+// The pages that could match: those with a matching word or title
+func (w *wordIndex) candidates(re *regexp.Regexp) map[string]bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	found := make(map[string]bool)
+	for word, titles := range w.words {
+		if re.MatchString(word) {
+			for title := range titles {
+				found[title] = true
+			}
+		}
+	}
+	for title := range w.pages {
+		if re.MatchString(title) {
+			found[title] = true
+		}
+	}
+	return found
+}
+
+// The word index can be trusted once a pass over every page has finished,
+// and isn't being redone; until then every page is read
+func wordIndexReady() bool {
+	s := indexer.progress()
+	return !s.Running && s.Error == "" && !s.Finished.IsZero()
+}
+
+// Build a case-insensitive pattern matching any of the query terms
+func searchPattern(query string) *regexp.Regexp {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	for i, term := range terms {
+		terms[i] = regexp.QuoteMeta(term)
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(terms, "|"))
+}
+
+// Look through the pages for the query terms, ranking title matches above
+// body matches. Only pages the word index says could match are read.
+func searchPages(r *http.Request, query string) ([]searchResult, error) {
+	re := searchPattern(query)
+	if re == nil {
+		return nil, nil
+	}
+	if s, ok := store.(searcher); ok {
+		return indexedSearch(r, s, query, re)
+	}
+	titles, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	if wordIndexReady() {
+		candidates := words.candidates(re)
+		titles = slices.DeleteFunc(titles, func(title string) bool { return !candidates[title] })
+	}
+	titles = readableTitles(r, titles)
+
+	var results []searchResult
+	for _, title := range titles {
+		p, err := loadPage(r.Context(), title)
+		if err != nil {
+			if r.Context().Err() != nil {
+				return nil, r.Context().Err()
+			}
+			continue
+		}
+		matches := re.FindAllIndex(p.Body, -1)
+		score := len(matches) + 10*len(re.FindAllStringIndex(title, -1))
+		if score == 0 {
+			continue
+		}
+		results = append(results, searchResult{
+			Title:   title,
+			Score:   score,
+			Snippet: snippet(p.Body, re, matches),
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	return results, nil
+}
+
+// Search with the store's own index, which has already ranked the results
+func indexedSearch(r *http.Request, s searcher, query string, re *regexp.Regexp) ([]searchResult, error) {
+	pages, err := s.Search(query)
+	if err != nil {
+		return nil, err
+	}
+	var results []searchResult
+	for i, p := range pages {
+		if perm, err := pagePermission(r, p.Title); err != nil || perm < permRead {
+			continue
+		}
+		results = append(results, searchResult{
+			Title:   p.Title,
+			Score:   len(pages) - i,
+			Snippet: snippet(p.Body, re, re.FindAllIndex(p.Body, -1)),
+		})
+	}
+	return results, nil
+}
+
+// Cut a window of the body around the first match, escaping it and marking every match inside.
+// Pages that only matched on their title show the start of the body.
+func snippet(body []byte, re *regexp.Regexp, matches [][]int) template.HTML {
+	start, end := 0, min(2*snippetContext, len(body))
+	if len(matches) > 0 {
+		start = max(matches[0][0]-snippetContext, 0)
+		end = min(matches[0][1]+snippetContext, len(body))
+	}
+	for start > 0 && !utf8.RuneStart(body[start]) {
+		start--
+	}
+	for end < len(body) && !utf8.RuneStart(body[end]) {
+		end++
+	}
+	window := body[start:end]
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	last := 0
+	for _, m := range re.FindAllIndex(window, -1) {
+		b.WriteString(template.HTMLEscapeString(string(window[last:m[0]])))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(string(window[m[0]:m[1]])))
+		b.WriteString("</mark>")
+		last = m[1]
+	}
+	b.WriteString(template.HTMLEscapeString(string(window[last:])))
+	if end < len(body) {
+		b.WriteString("…")
+	}
+	return template.HTML(b.String())
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	results, err := searchPages(r, query)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "search", struct {
+		Query   string
+		Results []searchResult
+	}{query, results})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookie   = "session"
+	sessionLifetime = 7 * 24 * time.Hour
+)
+
+type session struct {
+	Username string
+	Expires  time.Time
+}
+
+// SessionStore keeps track of who is logged in. Sessions are in memory by
+// default; keep them in Redis to share them between several wiki servers.
+type SessionStore interface {
+	// Create starts a session for the user, returning its ID
+	Create(username string) (string, session, error)
+	// Get finds a session that hasn't expired
+	Get(id string) (session, bool)
+	Delete(id string)
+}
+
+// Sessions kept in memory are lost when the server restarts, logging everyone out
+type memorySessions struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+var sessions SessionStore = &memorySessions{sessions: make(map[string]session)}
+
+func openSessions() error {
+	switch config.SessionStore {
+	case "memory", "":
+	case "redis":
+		rs, err := openRedisSessions()
+		if err != nil {
+			return err
+		}
+		sessions = rs
+	default:
+		return fmt.Errorf("unknown session store %q", config.SessionStore)
+	}
+	return nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func newSession(username string) (string, session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return "", session{}, err
+	}
+	return id, session{Username: username, Expires: time.Now().Add(sessionLifetime)}, nil
+}
+
+func (s *memorySessions) Create(username string) (string, session, error) {
+	id, sess, err := newSession(username)
+	if err != nil {
+		return "", session{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[id] = sess
+	return id, sess, nil
+}
+
+func (s *memorySessions) Get(id string) (session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if ok && time.Now().After(sess.Expires) {
+		delete(s.sessions, id)
+		return session{}, false
+	}
+	return sess, ok
+}
+
+func (s *memorySessions) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// Start a session for the user and hand the browser its cookie
+func startSession(w http.ResponseWriter, r *http.Request, username string) error {
+	id, sess, err := sessions.Create(username)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     cookiePath(),
+		Expires:  sess.Expires,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func endSession(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		sessions.Delete(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     cookiePath(),
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	requestIDKey
+)
+
+// session middleware: looks up the session cookie and attaches the logged in user to the request
+func sessionHandler(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			if sess, ok := sessions.Get(c.Value); ok {
+				if user := users.get(sess.Username); user != nil {
+					r = r.WithContext(context.WithValue(r.Context(), userKey, user))
+				}
+			}
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// The logged in user for a request, or nil for anonymous visitors
+func currentUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userKey).(*User)
+	return user
+}
+
+// The logged in user's name, or empty for anonymous visitors
+func username(r *http.Request) string {
+	if user := currentUser(r); user != nil {
+		return user.Username
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// Settings admins change from /admin, saved to data/.settings.json. What's
+// saved there takes the place of the config file and flags when the wiki
+// starts, so a change made while it runs outlasts a restart.
+type siteSettings struct {
+	ReadOnly *bool  `json:"read_only,omitempty"`
+	SiteName string `json:"site_name,omitempty"`
+	HomePage string `json:"home_page,omitempty"`
+}
+
+var settings = struct {
+	sync.RWMutex
+	saved siteSettings
+}{}
+
+func settingsFile() string {
+	return dataPath(".settings.json")
+}
+
+// Read the saved settings over the config, before anything is served
+func loadSettings() error {
+	data, err := os.ReadFile(settingsFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	settings.Lock()
+	defer settings.Unlock()
+	if err := json.Unmarshal(data, &settings.saved); err != nil {
+		return err
+	}
+	applySettings(settings.saved)
+	return nil
+}
+
+// Put saved settings into effect; callers must hold the lock
+func applySettings(s siteSettings) {
+	if s.ReadOnly != nil {
+		config.ReadOnly = *s.ReadOnly
+		readOnly.Store(*s.ReadOnly)
+	}
+	if s.SiteName != "" {
+		config.SiteName = s.SiteName
+	}
+	if s.HomePage != "" {
+		config.HomePage = s.HomePage
+	}
+}
+
+// Change the settings, save them and put them into effect
+func saveSettings(fn func(s *siteSettings)) error {
+	settings.Lock()
+	defer settings.Unlock()
+	s := settings.saved
+	fn(&s)
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(settingsFile(), data); err != nil {
+		return err
+	}
+	settings.saved = s
+	applySettings(s)
+	return nil
+}
+
+// What the wiki is called, now that admins can rename it while it runs
+func siteName() string {
+	settings.RLock()
+	defer settings.RUnlock()
+	return config.SiteName
+}
+
+// The page / shows
+func homePage() string {
+	settings.RLock()
+	defer settings.RUnlock()
+	return config.HomePage
+}
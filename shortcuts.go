@@ -0,0 +1,27 @@
+package main
+
+import "net/http"
+
+// A keyboard shortcut handled by static/shortcuts.js. Pages mark what a key
+// does with data-shortcut, so a key only does anything where it makes sense.
+type shortcut struct {
+	Keys   []string
+	Action string
+	Where  string
+}
+
+// The shortcuts, as /help/shortcuts lists them. None of them fire while
+// typing in a box, but for saving with Ctrl+S.
+var shortcuts = []shortcut{
+	{[]string{"e"}, "Edit the page", "Reading a page"},
+	{[]string{"h"}, "Show the page's history", "Reading, editing or comparing a page"},
+	{[]string{"s"}, "Save the page", "Editing"},
+	{[]string{"Ctrl+S", "⌘S"}, "Save the page, even while typing in it", "Editing"},
+	{[]string{"/"}, "Search the wiki", "Anywhere"},
+	{[]string{"?"}, "Show this list of shortcuts", "Anywhere"},
+}
+
+// /help/shortcuts lists the keyboard shortcuts
+func shortcutsHandler(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, r, "shortcuts", shortcuts)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"sync"
+)
+
+// sidebarCache keeps the rendered sidebar page, which every view shows, so
+// it isn't loaded from the store each time. There's a rendering for each
+// language it's been shown in, all dropped along with the page's other
+// renderings when the sidebar or a page it links to changes.
+type sidebarCache struct {
+	mu   sync.Mutex
+	html map[string]template.HTML
+}
+
+var sidebar = &sidebarCache{}
+
+// The sidebar page's rendering, or nothing if there's no sidebar page, in
+// which case views show the default navigation. Like an included page, it's
+// only shown if anyone may read it.
+func (s *sidebarCache) get(l *locale) template.HTML {
+	if config.Sidebar == "" {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	html, ok := s.html[l.Code]
+	if !ok {
+		if p, err := loadPage(context.Background(), config.Sidebar); err == nil && includable(config.Sidebar) {
+			html = p.HTML(l)
+		}
+		if s.html == nil {
+			s.html = make(map[string]template.HTML)
+		}
+		s.html[l.Code] = html
+	}
+	return html
+}
+
+func (s *sidebarCache) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.html = nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+)
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// List every page the requester can read, which for search engines means the public ones
+func sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := store.List()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	set := sitemapURLSet{URLs: []sitemapURL{}}
+	for _, title := range readableTitles(r, titles) {
+		u := sitemapURL{Loc: absoluteURL(r, pageURL("view", title))}
+		if modified := pageModTime(title); !modified.IsZero() {
+			u.LastMod = modified.UTC().Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, u)
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(set); err != nil {
+		serverError(w, r, err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The edit form's hidden fields for anonymous visitors: a honeypot people
+// never see, so never fill in, and a signed note of when the form was shown
+const (
+	honeypotField = "website"
+	startedField  = "started"
+)
+
+var spamLink = regexp.MustCompile(`(?i)https?://`)
+
+// Signs the time the edit form was shown, so it can't be made up. It's new
+// each time the wiki starts, which just means forms from before then have
+// to be saved twice.
+var spamKey = func() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}()
+
+// An anonymous edit about to be saved, with what the page said before
+type anonymousEdit struct {
+	Title string
+	Old   []byte
+	New   []byte
+}
+
+// spamFilter looks over an anonymous edit before it's saved, returning why
+// it should be refused, or "" to let it through. Filters beyond the built-in
+// ones can be added to spamFilters.
+type spamFilter interface {
+	check(e anonymousEdit) string
+}
+
+var spamFilters = []spamFilter{linkFilter{}, wordFilter{}}
+
+// linkFilter refuses edits adding more links than -spam-max-links
+type linkFilter struct{}
+
+func (linkFilter) check(e anonymousEdit) string {
+	added := len(spamLink.FindAllIndex(e.New, -1)) - len(spamLink.FindAllIndex(e.Old, -1))
+	if config.SpamMaxLinks > 0 && added > config.SpamMaxLinks {
+		return fmt.Sprintf("adds %d links, more than the %d allowed", added, config.SpamMaxLinks)
+	}
+	return ""
+}
+
+// wordFilter refuses edits adding any of -spam-words
+type wordFilter struct{}
+
+func (wordFilter) check(e anonymousEdit) string {
+	old, body := strings.ToLower(string(e.Old)), strings.ToLower(string(e.New))
+	for _, word := range config.SpamWords {
+		word = strings.ToLower(word)
+		if strings.Count(body, word) > strings.Count(old, word) {
+			return "adds the blocked word " + strconv.Quote(word)
+		}
+	}
+	return ""
+}
+
+func signStarted(started string) string {
+	return hex.EncodeToString(hmacSHA256(spamKey, started))
+}
+
+// The value for the form's started field: when it was shown, and a signature
+func startedToken() string {
+	started := strconv.FormatInt(time.Now().Unix(), 10)
+	return started + "." + signStarted(started)
+}
+
+// When the form was shown, if the token is one we handed out
+func parseStartedToken(token string) (time.Time, bool) {
+	started, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signStarted(started))) {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(started, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
+
+// Check an anonymous save for the signs of a spam bot, returning why it was
+// refused, or "" if it wasn't. Refusals are logged and audited.
+func checkSpam(r *http.Request, title string, body []byte) string {
+	reason := spamReason(r, title, body)
+	if reason != "" {
+		log.Printf("Refused anonymous edit of %s from %s: %s\n", title, clientIP(r), reason)
+		audit(r, "spam", title, reason)
+	}
+	return reason
+}
+
+func spamReason(r *http.Request, title string, body []byte) string {
+	if config.SpamHoneypot && r.PostFormValue(honeypotField) != "" {
+		return "filled in the hidden field"
+	}
+	if config.SpamMinSeconds > 0 {
+		started, ok := parseStartedToken(r.PostFormValue(startedField))
+		if !ok {
+			return "came from an edit form that has expired"
+		}
+		if time.Since(started) < time.Duration(config.SpamMinSeconds)*time.Second {
+			return "saved too soon after opening the editor"
+		}
+	}
+	e := anonymousEdit{Title: title, New: body}
+	if p, err := store.Load(r.Context(), title); err == nil {
+		e.Old = p.Body
+	}
+	for _, f := range spamFilters {
+		if reason := f.check(e); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// The libraries diagrams are drawn and math typeset with, kept in
+// static/vendor so they're built in rather than fetched from a CDN, and
+// work on wikis with no way out to the internet. These fetch the pinned
+// releases.
+//go:generate mkdir -p static/vendor
+//go:generate curl -fsSL -o static/vendor/mermaid.min.js https://cdn.jsdelivr.net/npm/mermaid@10.9.1/dist/mermaid.min.js
+//go:generate curl -fsSL -o static/vendor/viz-standalone.js https://cdn.jsdelivr.net/npm/@viz-js/viz@3.4.0/lib/viz-standalone.js
+//go:generate sh -c "curl -fsSL https://github.com/KaTeX/KaTeX/releases/download/v0.16.11/katex.tar.gz | tar -xz -C static/vendor katex/katex.min.js katex/katex.min.css katex/fonts"
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// How long browsers may cache static assets outside dev mode
+const staticMaxAge = "86400"
+
+// overlayFS serves files from a theme override directory when present,
+// falling back to the assets built into the binary
+type overlayFS struct {
+	override fs.FS
+	base     fs.FS
+}
+
+// ReadDir lists a directory of both together, so files only the base has
+// still show up when the override directory has some of its own
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(o.base, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if o.override != nil {
+		overrides, oerr := fs.ReadDir(o.override, name)
+		if oerr != nil && !errors.Is(oerr, fs.ErrNotExist) {
+			return nil, oerr
+		}
+		if oerr == nil {
+			err = nil
+		}
+		for _, entry := range overrides {
+			i := slices.IndexFunc(entries, func(e fs.DirEntry) bool { return e.Name() == entry.Name() })
+			if i >= 0 {
+				entries[i] = entry
+			} else {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if o.override != nil {
+		f, err := o.override.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return o.base.Open(name)
+}
+
+// The static files, with any overrides from the static directory
+func staticFS() fs.FS {
+	base, err := fs.Sub(embeddedStatic, "static")
+	if err != nil {
+		// the embed directive guarantees the directory exists
+		panic(err)
+	}
+	files := overlayFS{base: base}
+	if config.StaticDir != "" {
+		files.override = os.DirFS(config.StaticDir)
+	}
+	return files
+}
+
+func staticHandler() http.Handler {
+	fileServer := http.StripPrefix("/static/", http.FileServer(http.FS(staticFS())))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// no directory listings
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if config.Dev {
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Cache-Control", "public, max-age="+staticMaxAge)
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
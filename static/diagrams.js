@@ -0,0 +1,78 @@
+// Draw the mermaid and graphviz blocks the server marked up, loading each library only when a page needs it.
+// The libraries are served from static/vendor beside this script, so diagrams work without reaching a CDN.
+(function () {
+  const vendor = new URL("vendor/", document.currentScript.src);
+  const libraries = {
+    mermaid: new URL("mermaid.min.js", vendor).href,
+    graphviz: new URL("viz-standalone.js", vendor).href,
+  };
+  const loading = {};
+
+  function load(kind) {
+    if (!loading[kind]) {
+      loading[kind] = new Promise(function (resolve, reject) {
+        const script = document.createElement("script");
+        script.src = libraries[kind];
+        script.onload = resolve;
+        script.onerror = reject;
+        document.head.appendChild(script);
+      });
+    }
+    return loading[kind];
+  }
+
+  // Show what went wrong in place of the diagram, keeping its source readable
+  function failed(el, err) {
+    el.classList.add("diagram-error");
+    el.title = String(err);
+  }
+
+  function drawMermaid(blocks) {
+    load("mermaid").then(function () {
+      window.mermaid.initialize({ startOnLoad: false, securityLevel: "strict" });
+      blocks.forEach(function (el, i) {
+        window.mermaid
+          .render("diagram-" + Date.now() + "-" + i, el.textContent)
+          .then(function (result) {
+            el.outerHTML = '<div class="diagram">' + result.svg + "</div>";
+          })
+          .catch(function (err) {
+            failed(el, err);
+          });
+      });
+    });
+  }
+
+  function drawGraphviz(blocks) {
+    load("graphviz")
+      .then(function () {
+        return window.Viz.instance();
+      })
+      .then(function (viz) {
+        blocks.forEach(function (el) {
+          try {
+            const svg = viz.renderSVGElement(el.textContent);
+            const div = document.createElement("div");
+            div.className = "diagram";
+            div.appendChild(svg);
+            el.replaceWith(div);
+          } catch (err) {
+            failed(el, err);
+          }
+        });
+      });
+  }
+
+  window.renderDiagrams = function (root) {
+    const mermaid = root.querySelectorAll("pre.diagram-mermaid");
+    const graphviz = root.querySelectorAll("pre.diagram-graphviz");
+    if (mermaid.length > 0) {
+      drawMermaid(mermaid);
+    }
+    if (graphviz.length > 0) {
+      drawGraphviz(graphviz);
+    }
+  };
+
+  window.renderDiagrams(document);
+})();
@@ -0,0 +1,26 @@
+// Autosave the edit form as a server-side draft while the user types
+(function () {
+  const form = document.querySelector("form[data-draft]");
+  if (!form) {
+    return;
+  }
+  const status = document.getElementById("draft-status");
+  let saved = new URLSearchParams(new FormData(form)).toString();
+
+  setInterval(function () {
+    const current = new URLSearchParams(new FormData(form)).toString();
+    if (current === saved) {
+      return;
+    }
+    fetch(form.dataset.draft, { method: "POST", body: new URLSearchParams(new FormData(form)) })
+      .then(function (resp) {
+        if (resp.ok) {
+          saved = current;
+          status.textContent = "Draft saved at " + new Date().toLocaleTimeString();
+        }
+      })
+      .catch(function () {
+        status.textContent = "Couldn't save a draft";
+      });
+  }, 10000);
+})();
@@ -0,0 +1,102 @@
+// Suggest emoji shortcodes while the user types one, like :smi
+(function () {
+  const form = document.querySelector("form[data-emoji]");
+  const list = document.getElementById("emoji-suggestions");
+  if (!form || !list) {
+    return;
+  }
+  const body = form.querySelector("textarea[name=body]");
+  let suggestions = [];
+  let selected = 0;
+
+  // The shortcode being typed just before the cursor, without its colon
+  function typing() {
+    const before = body.value.slice(0, body.selectionStart);
+    const m = before.match(/(^|\s):([a-z0-9_+-]{2,})$/);
+    return m ? m[2] : null;
+  }
+
+  function hide() {
+    suggestions = [];
+    list.hidden = true;
+  }
+
+  function show() {
+    list.innerHTML = "";
+    suggestions.forEach(function (s, i) {
+      const item = document.createElement("li");
+      item.textContent = s.emoji + " :" + s.name + ":";
+      if (i === selected) {
+        item.className = "selected";
+      }
+      item.addEventListener("mousedown", function (event) {
+        event.preventDefault();
+        choose(i);
+      });
+      list.appendChild(item);
+    });
+    list.hidden = suggestions.length === 0;
+  }
+
+  function choose(i) {
+    const code = typing();
+    if (code === null) {
+      hide();
+      return;
+    }
+    const start = body.selectionStart - code.length - 1;
+    const text = ":" + suggestions[i].name + ": ";
+    body.setRangeText(text, start, body.selectionStart, "end");
+    body.dispatchEvent(new Event("input"));
+    hide();
+  }
+
+  body.addEventListener("input", function () {
+    const code = typing();
+    if (code === null) {
+      hide();
+      return;
+    }
+    fetch(form.dataset.emoji + "?q=" + encodeURIComponent(code))
+      .then(function (resp) {
+        return resp.ok ? resp.json() : [];
+      })
+      .then(function (found) {
+        if (typing() !== code) {
+          return;
+        }
+        suggestions = found;
+        selected = 0;
+        show();
+      })
+      .catch(hide);
+  });
+
+  body.addEventListener("keydown", function (event) {
+    if (list.hidden) {
+      return;
+    }
+    switch (event.key) {
+      case "ArrowDown":
+        selected = (selected + 1) % suggestions.length;
+        show();
+        break;
+      case "ArrowUp":
+        selected = (selected + suggestions.length - 1) % suggestions.length;
+        show();
+        break;
+      case "Enter":
+      case "Tab":
+        choose(selected);
+        break;
+      case "Escape":
+        hide();
+        break;
+      default:
+        return;
+    }
+    event.preventDefault();
+  });
+
+  body.addEventListener("blur", hide);
+})();
@@ -0,0 +1,45 @@
+// Render the edit form's body beside it as the user types, over a WebSocket
+(function () {
+  const form = document.querySelector("form[data-live-preview]");
+  const preview = document.getElementById("live-preview");
+  if (!form || !preview || !window.WebSocket) {
+    return;
+  }
+  const body = form.querySelector("textarea[name=body]");
+  const scheme = location.protocol === "https:" ? "wss:" : "ws:";
+  let socket = null;
+  let timer = null;
+
+  function connect() {
+    socket = new WebSocket(scheme + "//" + location.host + form.dataset.livePreview);
+    socket.onopen = send;
+    socket.onmessage = function (event) {
+      preview.innerHTML = event.data;
+      if (window.renderMath) {
+        window.renderMath(preview);
+      }
+      if (window.renderDiagrams) {
+        window.renderDiagrams(preview);
+      }
+    };
+    socket.onclose = function () {
+      socket = null;
+    };
+  }
+
+  function send() {
+    if (!socket) {
+      connect();
+      return;
+    }
+    if (socket.readyState === WebSocket.OPEN) {
+      socket.send(body.value);
+    }
+  }
+
+  body.addEventListener("input", function () {
+    clearTimeout(timer);
+    timer = setTimeout(send, 300);
+  });
+  connect();
+})();
@@ -0,0 +1,40 @@
+// Typeset the math the server marked up, loading KaTeX only when a page has some.
+// KaTeX and its fonts are served from static/vendor/katex beside this script.
+(function () {
+  const katexURL = new URL("vendor/katex/", document.currentScript.src).href;
+  let loading = null;
+
+  function loadKaTeX() {
+    if (!loading) {
+      loading = new Promise(function (resolve, reject) {
+        const css = document.createElement("link");
+        css.rel = "stylesheet";
+        css.href = katexURL + "katex.min.css";
+        document.head.appendChild(css);
+        const script = document.createElement("script");
+        script.src = katexURL + "katex.min.js";
+        script.onload = resolve;
+        script.onerror = reject;
+        document.head.appendChild(script);
+      });
+    }
+    return loading;
+  }
+
+  window.renderMath = function (root) {
+    const elements = root.querySelectorAll(".math");
+    if (elements.length === 0) {
+      return;
+    }
+    loadKaTeX().then(function () {
+      elements.forEach(function (el) {
+        window.katex.render(el.textContent, el, {
+          displayMode: el.classList.contains("math-display"),
+          throwOnError: false,
+        });
+      });
+    });
+  };
+
+  window.renderMath(document);
+})();
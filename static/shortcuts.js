@@ -0,0 +1,47 @@
+// Keyboard shortcuts, listed at /help/shortcuts. A key does whatever the
+// element marked with its data-shortcut does: links are followed, buttons
+// pressed and boxes focused.
+(function () {
+  const script = document.currentScript;
+
+  // Don't take keys meant for a box being typed in
+  function typing(target) {
+    return target.isContentEditable || ["INPUT", "TEXTAREA", "SELECT"].includes(target.tagName);
+  }
+
+  function activate(el) {
+    if (el.tagName === "INPUT" && !["submit", "button"].includes(el.type)) {
+      el.focus();
+      el.select();
+    } else {
+      el.click();
+    }
+  }
+
+  document.addEventListener("keydown", function (event) {
+    // Ctrl+S or Cmd+S saves while typing, rather than saving the web page
+    if ((event.ctrlKey || event.metaKey) && !event.altKey && event.key.toLowerCase() === "s") {
+      const save = document.querySelector('[data-shortcut="s"]');
+      if (save) {
+        event.preventDefault();
+        activate(save);
+      }
+      return;
+    }
+    if (event.ctrlKey || event.metaKey || event.altKey || event.defaultPrevented || typing(event.target)) {
+      return;
+    }
+    const key = event.key;
+    const el = document.querySelector('[data-shortcut="' + CSS.escape(key) + '"]');
+    if (el) {
+      event.preventDefault();
+      activate(el);
+    } else if (key === "/") {
+      event.preventDefault();
+      window.location = script.dataset.search;
+    } else if (key === "?") {
+      event.preventDefault();
+      window.location = script.dataset.help;
+    }
+  });
+})();
@@ -0,0 +1,151 @@
+// Formatting buttons over the editor, which wrap the selection in the
+// wiki's markup, and a link button suggesting pages as their title is typed
+(function () {
+  const form = document.querySelector("form[data-titles]");
+  const toolbar = document.getElementById("edit-toolbar");
+  if (!form || !toolbar) {
+    return;
+  }
+  const body = form.querySelector("textarea[name=body]");
+  const linkRow = toolbar.querySelector(".toolbar-link");
+  const linkInput = linkRow.querySelector("input");
+  const suggestions = document.getElementById("title-suggestions");
+  // where the link goes, kept while the link row has the focus
+  let linkStart = 0;
+  let linkEnd = 0;
+
+  function changed() {
+    body.dispatchEvent(new Event("input"));
+    body.focus();
+  }
+
+  // Put markup either side of the selection, or of some placeholder text to type over
+  function wrap(before, after, placeholder) {
+    const start = body.selectionStart;
+    const end = body.selectionEnd;
+    const text = body.value.slice(start, end) || placeholder;
+    body.setRangeText(before + text + after, start, end, "end");
+    body.setSelectionRange(start + before.length, start + before.length + text.length);
+    changed();
+  }
+
+  // Change every line the selection touches
+  function eachLine(change) {
+    const start = body.value.lastIndexOf("\n", body.selectionStart - 1) + 1;
+    let end = body.value.indexOf("\n", body.selectionEnd);
+    if (end < 0) {
+      end = body.value.length;
+    }
+    const lines = body.value.slice(start, end).split("\n").map(change);
+    body.setRangeText(lines.join("\n"), start, end, "select");
+    changed();
+  }
+
+  const formats = {
+    bold: function () {
+      wrap("**", "**", "bold text");
+    },
+    italic: function () {
+      wrap("*", "*", "italic text");
+    },
+    code: function () {
+      const selected = body.value.slice(body.selectionStart, body.selectionEnd);
+      if (selected.includes("\n")) {
+        wrap("```\n", "\n```", "");
+      } else {
+        wrap("`", "`", "code");
+      }
+    },
+    // # lines are headings, down to ###, and one more press takes the heading off
+    heading: function () {
+      eachLine(function (line) {
+        const m = line.match(/^(#{1,3})[ \t]+(.*)$/);
+        if (!m) {
+          return "# " + line;
+        }
+        return m[1].length < 3 ? "#" + line : m[2];
+      });
+    },
+    list: function () {
+      eachLine(function (line) {
+        return /^\s*([-*]|\d+\.)\s/.test(line) ? line : "- " + line;
+      });
+    },
+    link: function () {
+      linkStart = body.selectionStart;
+      linkEnd = body.selectionEnd;
+      linkInput.value = body.value.slice(linkStart, linkEnd).trim();
+      linkRow.hidden = false;
+      linkInput.focus();
+      suggest();
+    },
+  };
+
+  function closeLink() {
+    linkRow.hidden = true;
+    body.focus();
+  }
+
+  function insertLink() {
+    const title = linkInput.value.trim();
+    if (title === "") {
+      closeLink();
+      return;
+    }
+    body.setRangeText("[[" + title + "]]", linkStart, linkEnd, "end");
+    linkRow.hidden = true;
+    changed();
+  }
+
+  function suggest() {
+    const q = linkInput.value.trim();
+    if (q === "") {
+      suggestions.innerHTML = "";
+      return;
+    }
+    fetch(form.dataset.titles + "?q=" + encodeURIComponent(q))
+      .then(function (resp) {
+        return resp.ok ? resp.json() : [];
+      })
+      .then(function (titles) {
+        if (linkInput.value.trim() !== q) {
+          return;
+        }
+        suggestions.innerHTML = "";
+        titles.forEach(function (title) {
+          const option = document.createElement("option");
+          option.value = title;
+          suggestions.appendChild(option);
+        });
+      })
+      .catch(function () {});
+  }
+
+  toolbar.addEventListener("click", function (event) {
+    const button = event.target.closest("button");
+    if (!button) {
+      return;
+    }
+    if (button.dataset.format) {
+      formats[button.dataset.format]();
+    } else if (button.dataset.link === "insert") {
+      insertLink();
+    } else if (button.dataset.link === "cancel") {
+      closeLink();
+    }
+  });
+
+  linkInput.addEventListener("input", suggest);
+  linkInput.addEventListener("keydown", function (event) {
+    if (event.key === "Enter") {
+      // not the form's submit
+      event.preventDefault();
+      insertLink();
+    } else if (event.key === "Escape") {
+      event.preventDefault();
+      closeLink();
+    }
+  });
+
+  toolbar.hidden = false;
+})();
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// How many days of edits the chart on /admin/stats covers, today included
+	statsDays = 30
+	// How many of the most edited pages and busiest authors it lists
+	statsTop = 10
+)
+
+// How much room the wiki takes up, by what it's taken by
+type storageUsage struct {
+	Pages       int64
+	History     int64
+	Attachments int64
+	Other       int64 // accounts, the change log and the rest of the wiki's own records
+}
+
+func (u storageUsage) Total() int64 {
+	return u.Pages + u.History + u.Attachments + u.Other
+}
+
+func (u storageUsage) add(more storageUsage) storageUsage {
+	return storageUsage{u.Pages + more.Pages, u.History + more.History, u.Attachments + more.Attachments, u.Other + more.Other}
+}
+
+// Stores that keep pages somewhere besides the data directory implement
+// usageReporter, to say how much room they take there
+type usageReporter interface {
+	Usage(ctx context.Context) (storageUsage, error)
+}
+
+// What's in the data directory: pages, their history under .history (or
+// .git, for the git store), attachments, and everything else
+func dataDirUsage() (storageUsage, error) {
+	var u storageUsage
+	err := filepath.WalkDir(config.DataDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(config.DataDir, file)
+		if err != nil {
+			return err
+		}
+		top, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+		switch {
+		case top == ".history" || top == ".git":
+			u.History += info.Size()
+		case top == "attachments":
+			u.Attachments += info.Size()
+		case !strings.HasPrefix(top, ".") && strings.HasSuffix(rel, ".txt"):
+			u.Pages += info.Size()
+		default:
+			u.Other += info.Size()
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return u, err
+}
+
+// The data directory, and wherever else the store keeps things
+func measureStorage(ctx context.Context) (storageUsage, error) {
+	u, err := dataDirUsage()
+	if err != nil {
+		return u, err
+	}
+	if reporter, ok := store.(usageReporter); ok {
+		more, err := reporter.Usage(ctx)
+		if err != nil {
+			return u, err
+		}
+		u = u.add(more)
+	}
+	return u, nil
+}
+
+// The templates' size function: sizes to a decimal place, for reading at a
+// glance rather than exactly
+func roughSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// The edits made on one day, with how tall its bar is next to the busiest
+type dayEdits struct {
+	Day     time.Time
+	Edits   int
+	Percent int
+}
+
+// A page or author, with how many edits they've had
+type editCount struct {
+	Name  string
+	Edits int
+}
+
+// The most edited first, then by name
+func topCounts(counts map[string]int, n int) []editCount {
+	list := make([]editCount, 0, len(counts))
+	for name, edits := range counts {
+		list = append(list, editCount{name, edits})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Edits != list[j].Edits {
+			return list[i].Edits > list[j].Edits
+		}
+		return list[i].Name < list[j].Name
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+type wikiStats struct {
+	Pages     int
+	Revisions int
+	Edits     int // in the change log, which starts when the wiki first saved a page
+	Days      []dayEdits
+	TopPages  []editCount
+	Authors   []editCount
+	Storage   storageUsage
+}
+
+// Count the pages and their revisions from the store, and the edits in the
+// change log: by day over the last month, in the viewer's time zone, and
+// by page and author over all of it
+func collectStats(ctx context.Context, zone *time.Location, now time.Time) (*wikiStats, error) {
+	titles, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	s := &wikiStats{Pages: len(titles)}
+	for _, title := range titles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		revs, err := store.Revisions(title)
+		if err != nil {
+			return nil, err
+		}
+		s.Revisions += len(revs)
+	}
+
+	now = now.In(zone)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, zone)
+	first := today.AddDate(0, 0, 1-statsDays)
+	s.Days = make([]dayEdits, statsDays)
+	for i := range s.Days {
+		s.Days[i].Day = first.AddDate(0, 0, i)
+	}
+	pages, authors := make(map[string]int), make(map[string]int)
+	err = readChanges(func(c Change) {
+		s.Edits++
+		pages[c.Title]++
+		authors[c.Author]++
+		t := c.Time.In(zone)
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, zone)
+		// counted in calendar days, as a day with a clock change isn't 24 hours
+		if i := int(day.Sub(first).Hours()/24 + 0.5); !day.Before(first) && i < statsDays {
+			s.Days[i].Edits++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	busiest := 0
+	for _, d := range s.Days {
+		busiest = max(busiest, d.Edits)
+	}
+	for i := range s.Days {
+		if busiest > 0 {
+			s.Days[i].Percent = s.Days[i].Edits * 100 / busiest
+		}
+	}
+	s.TopPages, s.Authors = topCounts(pages, statsTop), topCounts(authors, statsTop)
+
+	if s.Storage, err = measureStorage(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// /admin/stats shows how big the wiki is and how busy it's been
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := collectStats(r.Context(), requestZone(r), time.Now())
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "stats", s)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PageStore is where pages and their revisions are kept. Pages are addressed
+// by title; revisions are numbered from 1, oldest first. Loading, saving and
+// deleting are given the request's context, and give up when it's done.
+type PageStore interface {
+	List() ([]string, error)
+	Exists(title string) bool
+	Load(ctx context.Context, title string) (*Page, error)
+	// Save writes the page and records it as a new revision, or returns
+	// errUnchanged if the page already has that content
+	Save(ctx context.Context, p *Page, edit Edit) (*Revision, error)
+	// Delete removes the page but keeps its revisions, so saving it again carries on its history
+	Delete(ctx context.Context, title string, edit Edit) error
+	Revisions(title string) ([]Revision, error)
+	LoadRevision(title string, number int) ([]byte, error)
+}
+
+// Stores that can compute diffs between revisions themselves implement differ
+type differ interface {
+	Diff(title string, from, to int) ([]diffHunk, error)
+}
+
+// Stores that can forget a page's history implement historyPurger
+type historyPurger interface {
+	PurgeHistory(title string) error
+}
+
+// Stores that know when each page last changed implement modTimer
+type modTimer interface {
+	ModTime(title string) (time.Time, error)
+}
+
+// Stores that can list one namespace without listing every page implement namespaceLister
+type namespaceLister interface {
+	// ListNamespace gives the pages directly in a namespace, and the namespaces nested in it
+	ListNamespace(ns string) (pages, namespaces []string, err error)
+}
+
+// Stores that can go through their pages without holding a list of them all implement pageWalker
+type pageWalker interface {
+	// WalkPages calls fn with each page whose title starts with prefix, in
+	// title order, stopping at the first error fn returns
+	WalkPages(prefix string, fn func(title string) error) error
+}
+
+// Stores with a search index of their own implement searcher
+type searcher interface {
+	// Search gives the pages matching a query, best match first
+	Search(query string) ([]*Page, error)
+}
+
+var store PageStore = fileStore{}
+
+// Saving a page with the content it already has makes no new revision
+var errUnchanged = errors.New("the page already has that content")
+
+// Go through the pages starting with prefix, in title order. Stores that
+// can't walk their pages list them all and leave out the rest.
+func walkPages(prefix string, fn func(title string) error) error {
+	if w, ok := store.(pageWalker); ok {
+		return w.WalkPages(prefix, fn)
+	}
+	titles, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, title := range titles {
+		if !strings.HasPrefix(title, prefix) {
+			continue
+		}
+		if err := fn(title); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// When a page last changed, or the zero time if the store can't say
+func pageModTime(title string) time.Time {
+	if m, ok := store.(modTimer); ok {
+		if t, err := m.ModTime(title); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func openStore() error {
+	switch config.Storage {
+	case "file", "":
+		store = fileStore{}
+	case "git":
+		gs, err := openGitStore(config.DataDir)
+		if err != nil {
+			return err
+		}
+		store = gs
+	case "s3":
+		s3, err := openS3Store()
+		if err != nil {
+			return err
+		}
+		store, attachments = s3, s3Attachments{s3}
+	case "postgres":
+		pg, err := openPostgresStore()
+		if err != nil {
+			return err
+		}
+		store = pg
+	default:
+		return fmt.Errorf("unknown storage backend %q", config.Storage)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// A row like |:---|:---:|---:| under the first row of a table makes that row
+// its header and sets how each column is aligned
+var tableDivider = regexp.MustCompile(`^:?-+:?$`)
+
+// Turn runs of lines starting with | into tables. The text is escaped
+// already, and escaping leaves |, : and - alone.
+func renderTables(escaped []byte) []byte {
+	lines := bytes.Split(escaped, []byte("\n"))
+	var out [][]byte
+	for i := 0; i < len(lines); {
+		if !isTableRow(lines[i]) {
+			out = append(out, lines[i])
+			i++
+			continue
+		}
+		start := i
+		for i < len(lines) && isTableRow(lines[i]) {
+			i++
+		}
+		out = append(out, renderTable(lines[start:i]))
+	}
+	return bytes.Join(out, []byte("\n"))
+}
+
+func isTableRow(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(line), []byte("|"))
+}
+
+// Split a row into its trimmed cells, ignoring the pipes at either end
+func tableCells(line []byte) []string {
+	row := strings.TrimSpace(string(line))
+	row = strings.TrimPrefix(row, "|")
+	row = strings.TrimSuffix(row, "|")
+	cells := strings.Split(row, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+// The alignment of each column, if the row is a divider
+func tableAlignments(cells []string) ([]string, bool) {
+	aligns := make([]string, len(cells))
+	for i, cell := range cells {
+		if !tableDivider.MatchString(cell) {
+			return nil, false
+		}
+		left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":")
+		switch {
+		case left && right:
+			aligns[i] = "center"
+		case right:
+			aligns[i] = "right"
+		case left:
+			aligns[i] = "left"
+		}
+	}
+	return aligns, true
+}
+
+func renderTable(rows [][]byte) []byte {
+	var header []string
+	var aligns []string
+	if len(rows) > 1 {
+		if a, ok := tableAlignments(tableCells(rows[1])); ok {
+			header, aligns = tableCells(rows[0]), a
+			rows = rows[2:]
+		}
+	}
+	var b bytes.Buffer
+	b.WriteString(`<table class="wiki-table">`)
+	if header != nil {
+		b.WriteString("<thead>")
+		writeTableRow(&b, "th", header, aligns)
+		b.WriteString("</thead>")
+	}
+	b.WriteString("<tbody>")
+	for _, row := range rows {
+		writeTableRow(&b, "td", tableCells(row), aligns)
+	}
+	b.WriteString("</tbody></table>")
+	return b.Bytes()
+}
+
+func writeTableRow(b *bytes.Buffer, tag string, cells []string, aligns []string) {
+	b.WriteString("<tr>")
+	for i, cell := range cells {
+		b.WriteString("<" + tag)
+		if i < len(aligns) && aligns[i] != "" {
+			b.WriteString(` class="text-` + aligns[i] + `"`)
+		}
+		b.WriteString(">" + cell + "</" + tag + ">")
+	}
+	b.WriteString("</tr>")
+}
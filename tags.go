@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// tagIndex records the {{tag:name}} tags on each page
+type tagIndex struct {
+	mu   sync.RWMutex
+	tags map[string][]string
+}
+
+var tags = &tagIndex{tags: make(map[string][]string)}
+
+// Tags are case-insensitive and follow the same rules as titles
+func normalizeTag(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	return tag, validTitle(tag)
+}
+
+// Pull the distinct tags out of a page body, from its front matter as well as {{tag:name}}s
+func pageTags(body []byte) []string {
+	meta, _, _ := parseFrontMatter(body)
+	candidates := meta.Tags
+	for _, m := range tagLink.FindAllSubmatch(body, -1) {
+		candidates = append(candidates, string(m[1]))
+	}
+	var found []string
+	for _, candidate := range candidates {
+		if tag, ok := normalizeTag(candidate); ok && !slices.Contains(found, tag) {
+			found = append(found, tag)
+		}
+	}
+	return found
+}
+
+func (t *tagIndex) update(title string, body []byte) {
+	found := pageTags(body)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tags[title] = found
+}
+
+func (t *tagIndex) remove(title string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.tags, title)
+}
+
+// The pages carrying a tag, sorted
+func (t *tagIndex) pages(tag string) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var titles []string
+	for title, found := range t.tags {
+		if slices.Contains(found, tag) {
+			titles = append(titles, title)
+		}
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+// A tag in the cloud, sized from 1 to 5 by how many pages use it
+type tagCount struct {
+	Name  string
+	Count int
+	Size  int
+}
+
+// Add a page's tags to the counts a cloud is made from
+func (t *tagIndex) count(counts map[string]int, title string) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, tag := range t.tags[title] {
+		counts[tag]++
+	}
+}
+
+// The cloud of tags counted across pages, alphabetically
+func tagCloud(counts map[string]int) []tagCount {
+	cloud := make([]tagCount, 0, len(counts))
+	least, most := 0, 0
+	for name, count := range counts {
+		cloud = append(cloud, tagCount{Name: name, Count: count})
+		if least == 0 || count < least {
+			least = count
+		}
+		most = max(most, count)
+	}
+	for i := range cloud {
+		cloud[i].Size = 3
+		if most > least {
+			cloud[i].Size = 1 + 4*(cloud[i].Count-least)/(most-least)
+		}
+	}
+	sort.Slice(cloud, func(i, j int) bool { return cloud[i].Name < cloud[j].Name })
+	return cloud
+}
+
+func tagHandler(w http.ResponseWriter, r *http.Request, tag string) {
+	tag, ok := normalizeTag(tag)
+	if !ok {
+		notFound(w, r)
+		return
+	}
+	renderTemplate(w, r, "tag", struct {
+		Tag   string
+		Pages []string
+	}{tag, readableTitles(r, tags.pages(tag))})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Longest comment kept, in bytes
+const maxCommentLength = 10000
+
+var errNoComment = errors.New("no such comment")
+
+// A Comment on a page's talk page. Replies name the comment they answer as their Parent.
+type Comment struct {
+	ID     int       `json:"id"`
+	Parent int       `json:"parent,omitempty"`
+	Author string    `json:"author"`
+	Time   time.Time `json:"time"`
+	Body   string    `json:"body"`
+}
+
+// A comment with its replies, for showing the discussion as a thread.
+// Each carries what its reply form needs, since templates can't look back up the thread.
+type commentThread struct {
+	Comment
+	Replies  []*commentThread
+	Title    string
+	CanReply bool
+}
+
+// Each page's comments are kept together under data/.talk
+var talkMu sync.Mutex
+
+func talkFile(title string) string {
+	return filepath.Join(dataPath(".talk"), titleFileName(title)+".json")
+}
+
+func loadComments(title string) ([]Comment, error) {
+	data, err := os.ReadFile(talkFile(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var comments []Comment
+	return comments, json.Unmarshal(data, &comments)
+}
+
+func addComment(title string, c Comment) error {
+	talkMu.Lock()
+	defer talkMu.Unlock()
+	comments, err := loadComments(title)
+	if err != nil {
+		return err
+	}
+	// a reply has to answer a comment that's there
+	if c.Parent != 0 && (c.Parent > len(comments) || c.Parent < 0) {
+		return errNoComment
+	}
+	c.ID = len(comments) + 1
+	comments = append(comments, c)
+	if err := os.MkdirAll(filepath.Dir(talkFile(title)), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(comments, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(talkFile(title), data, 0600)
+}
+
+// How many comments a page has, for the badge on its view
+func commentCount(title string) int {
+	talkMu.Lock()
+	defer talkMu.Unlock()
+	comments, _ := loadComments(title)
+	return len(comments)
+}
+
+// Arrange comments into threads, oldest first at every level
+func threadComments(title string, comments []Comment, canReply bool) []*commentThread {
+	threads := make([]*commentThread, len(comments)+1)
+	var top []*commentThread
+	for _, c := range comments {
+		if c.ID < 1 || c.ID > len(comments) {
+			continue
+		}
+		t := &commentThread{Comment: c, Title: title, CanReply: canReply}
+		threads[c.ID] = t
+		if c.Parent > 0 && c.Parent < c.ID && threads[c.Parent] != nil {
+			threads[c.Parent].Replies = append(threads[c.Parent].Replies, t)
+		} else {
+			top = append(top, t)
+		}
+	}
+	return top
+}
+
+// Comments are plain text: blank lines separate paragraphs and line breaks are kept
+func (c Comment) HTML() template.HTML {
+	var b strings.Builder
+	for _, para := range strings.Split(strings.ReplaceAll(c.Body, "\r\n", "\n"), "\n\n") {
+		if para = strings.TrimSpace(para); para == "" {
+			continue
+		}
+		lines := strings.Split(para, "\n")
+		for i := range lines {
+			lines[i] = template.HTMLEscapeString(lines[i])
+		}
+		b.WriteString("<p>" + strings.Join(lines, "<br>") + "</p>")
+	}
+	return template.HTML(b.String())
+}
+
+func talkHandler(w http.ResponseWriter, r *http.Request, title string) {
+	talkMu.Lock()
+	comments, err := loadComments(title)
+	talkMu.Unlock()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "talk", struct {
+		Title    string
+		Exists   bool
+		Count    int
+		Threads  []*commentThread
+		LoggedIn bool
+	}{title, pageExists(title), len(comments), threadComments(title, comments, currentUser(r) != nil), currentUser(r) != nil})
+}
+
+// POST /comment/<title> adds a comment to the page's talk page, as a reply when parent is given
+func commentHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, pageURL("talk", title), http.StatusFound)
+		return
+	}
+	body := strings.TrimSpace(r.FormValue("body"))
+	if body == "" || len(body) > maxCommentLength {
+		httpError(w, r, http.StatusBadRequest, "Comments can't be empty or longer than "+strconv.Itoa(maxCommentLength)+" characters.")
+		return
+	}
+	parent, _ := strconv.Atoi(r.FormValue("parent"))
+	c := Comment{Parent: parent, Author: username(r), Time: time.Now().UTC(), Body: body}
+	err := addComment(title, c)
+	if errors.Is(err, errNoComment) {
+		httpError(w, r, http.StatusBadRequest, "The comment you replied to isn't there.")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	audit(r, "comment", title, "")
+	http.Redirect(w, r, pageURL("talk", title), http.StatusFound)
+}
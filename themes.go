@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A visitor's chosen theme is kept in this cookie for a year
+const (
+	themeCookie   = "theme"
+	themeLifetime = 365 * 24 * time.Hour
+)
+
+// The themes available, named after the CSS files under static/themes
+func listThemes() []string {
+	entries, err := fs.ReadDir(staticFS(), "themes")
+	if err != nil {
+		return nil
+	}
+	var themes []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".css"); ok && !entry.IsDir() {
+			themes = append(themes, name)
+		}
+	}
+	return themes
+}
+
+// The theme for a request: the visitor's choice if they've made one, else the site's
+func currentTheme(r *http.Request) string {
+	themes := listThemes()
+	if c, err := r.Cookie(themeCookie); err == nil && slices.Contains(themes, c.Value) {
+		return c.Value
+	}
+	return config.Theme
+}
+
+// Every page links /theme.css, which serves whichever theme applies to the
+// visitor, so templates don't need to know about themes
+func themeCSSHandler(w http.ResponseWriter, r *http.Request) {
+	css, err := fs.ReadFile(staticFS(), path.Join("themes", currentTheme(r)+".css"))
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	// the theme can change with the cookie, so caches must check back
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Add("Vary", "Cookie")
+	w.Write(css)
+}
+
+// POST picks a theme for this browser, going back to where the visitor came from
+func themeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "That page can only be reached by submitting a form.")
+		return
+	}
+	name := r.FormValue("theme")
+	if !slices.Contains(listThemes(), name) {
+		httpError(w, r, http.StatusBadRequest, "There's no theme called "+name+".")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     themeCookie,
+		Value:    name,
+		Path:     cookiePath(),
+		Expires:  time.Now().Add(themeLifetime),
+		SameSite: http.SameSiteLaxMode,
+		Secure:   isHTTPS(r),
+	})
+	back := r.Referer()
+	if !strings.HasPrefix(back, absoluteURL(r, sitePath("/"))) {
+		back = sitePath("/")
+	}
+	http.Redirect(w, r, back, http.StatusFound)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Give a handler's loading and saving a deadline of -request-timeout, so a
+// slow store fails the request in good time rather than holding it for the
+// server's whole two minutes. Page handlers get it from makeHandler; exports,
+// imports and backups take as long as they take, so they aren't wrapped.
+func withTimeout(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, cancel := timeLimited(r)
+		defer cancel()
+		fn(w, r)
+	}
+}
+
+// The request with a deadline of -request-timeout from now, if there is one
+func timeLimited(r *http.Request) (*http.Request, context.CancelFunc) {
+	if config.RequestTimeout <= 0 {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), config.RequestTimeout)
+	return r.WithContext(ctx), cancel
+}
+
+// Did the request run out of time waiting on the store?
+func timedOut(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	// zone data built in, for machines without /usr/share/zoneinfo
+	_ "time/tzdata"
+)
+
+// Times are stored in UTC and shown in the reader's zone: the one they've
+// set for their account, else -time-zone, which is the server's own unless
+// it's set.
+var siteZone = time.Local
+
+// Zones already loaded, by name, as every page render looks one up
+var zones sync.Map
+
+func loadZone(name string) (*time.Location, error) {
+	if z, ok := zones.Load(name); ok {
+		return z.(*time.Location), nil
+	}
+	z, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	zones.Store(name, z)
+	return z, nil
+}
+
+// Look up -time-zone, such as Europe/Berlin, UTC or Local
+func checkTimeZone() error {
+	z, err := loadZone(config.TimeZone)
+	if err != nil {
+		return fmt.Errorf("-time-zone must be a zone such as Europe/Berlin, UTC or Local, not %q", config.TimeZone)
+	}
+	siteZone = z
+	return nil
+}
+
+// What to call a zone on a form: its name, or for the server's own, which
+// time calls Local, what it goes by there, such as CET
+func zoneName(z *time.Location) string {
+	if z == time.Local {
+		name, _ := time.Now().Zone()
+		return name
+	}
+	return z.String()
+}
+
+// The zone to show a request's times in
+func requestZone(r *http.Request) *time.Location {
+	if user := currentUser(r); user != nil && user.TimeZone != "" {
+		if z, err := loadZone(user.TimeZone); err == nil {
+			return z
+		}
+	}
+	return siteZone
+}
+
+// The template functions for showing times in a zone: localTime formats one
+// there, e.g. {{localTime .Time "2006-01-02 15:04"}}, and ago says how long
+// ago it was, in the locale's words
+func zoneFuncs(l *locale, zone *time.Location) template.FuncMap {
+	return template.FuncMap{
+		"localTime": func(t time.Time, layout string) string { return t.In(zone).Format(layout) },
+		"ago":       func(t time.Time) string { return timeAgo(l, t, zone, time.Now()) },
+	}
+}
+
+// How long before now a time was, such as "5 minutes ago" or "yesterday".
+// Days go by the calendar in the zone, so last night at eleven is yesterday
+// even at one in the morning; after a week it's just the date.
+func timeAgo(l *locale, t time.Time, zone *time.Location, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return l.translate("just now")
+	case d < time.Hour:
+		return l.plural(int(d/time.Minute), "1 minute ago", "%d minutes ago")
+	}
+	t, now = t.In(zone), now.In(zone)
+	then := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, zone)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, zone)
+	days := int(today.Sub(then).Hours()/24 + 0.5)
+	switch {
+	case days == 0:
+		return l.plural(int(d/time.Hour), "1 hour ago", "%d hours ago")
+	case days == 1:
+		return l.translate("yesterday")
+	case days < 7:
+		return l.translate("%d days ago", days)
+	}
+	return t.Format("2006-01-02")
+}
+
+// One message for a single thing and another, given the count, for more
+func (l *locale) plural(n int, one, many string) string {
+	if n == 1 {
+		return l.translate(one)
+	}
+	return l.translate(many, n)
+}
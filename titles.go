@@ -0,0 +1,210 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Longest title allowed, in bytes, keeping file names well inside filesystem limits
+// once percent-encoded
+const maxTitleLength = 80
+
+// How many suggestions /titles.json gives at most
+const titleSuggestions = 10
+
+// Namespaces separate the parts of a title, as in projects/alpha/design
+const namespaceSeparator = "/"
+
+// Titles may use letters and digits from any script, plus single spaces, dashes
+// and underscores between them, and can be split into namespaces with slashes.
+// The attachments namespace is taken by the attachments directory.
+func validTitle(title string) bool {
+	if title == "" || len(title) > maxTitleLength || !utf8.ValidString(title) {
+		return false
+	}
+	parts := strings.Split(title, namespaceSeparator)
+	if parts[0] == "attachments" && len(parts) > 1 {
+		return false
+	}
+	for _, part := range parts {
+		if !validTitlePart(part) {
+			return false
+		}
+	}
+	return true
+}
+
+func validTitlePart(part string) bool {
+	if part == "" || strings.TrimSpace(part) != part || strings.Contains(part, "  ") {
+		return false
+	}
+	for _, r := range part {
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r) && r != ' ' && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// Titles are percent-encoded on disk so file names stay plain ASCII on every
+// filesystem. Plain alphanumeric titles map to themselves, and namespaces are
+// encoded too, leaving one flat name.
+func titleFileName(title string) string {
+	return url.PathEscape(title)
+}
+
+// Like titleFileName but keeping namespaces as directories, for page files and URLs
+func titlePath(title string) string {
+	parts := strings.Split(title, namespaceSeparator)
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// The namespaces a title sits in, outermost first: projects/alpha/design is
+// in projects and projects/alpha
+func titleNamespaces(title string) []string {
+	var namespaces []string
+	for i, r := range title {
+		if string(r) == namespaceSeparator {
+			namespaces = append(namespaces, title[:i])
+		}
+	}
+	return namespaces
+}
+
+// The last part of a title, without its namespaces
+func titleName(title string) string {
+	return title[strings.LastIndex(title, namespaceSeparator)+1:]
+}
+
+// Order titles so each namespace's pages follow it, before the next title
+// that merely starts with the same letters
+func compareTitles(a, b string) int {
+	return slices.Compare(strings.Split(a, namespaceSeparator), strings.Split(b, namespaceSeparator))
+}
+
+func titleFromFileName(name string) (string, bool) {
+	title, err := url.PathUnescape(name)
+	if err != nil || !validTitle(title) {
+		return "", false
+	}
+	return title, true
+}
+
+// titleIndex finds pages whatever case their titles are written in, so
+// homepage finds HomePage. A page's own title is canonical: an exact match
+// always wins, and otherwise it's the first title matching in another case.
+type titleIndex struct {
+	mu     sync.RWMutex
+	folded map[string][]string
+}
+
+var titleCases = &titleIndex{folded: make(map[string][]string)}
+
+func (ti *titleIndex) add(title string) {
+	key := strings.ToLower(title)
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	if !slices.Contains(ti.folded[key], title) {
+		ti.folded[key] = append(ti.folded[key], title)
+		slices.SortFunc(ti.folded[key], compareTitles)
+	}
+}
+
+func (ti *titleIndex) remove(title string) {
+	key := strings.ToLower(title)
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	ti.folded[key] = slices.DeleteFunc(ti.folded[key], func(t string) bool { return t == title })
+	if len(ti.folded[key]) == 0 {
+		delete(ti.folded, key)
+	}
+}
+
+// Every title indexed
+func (ti *titleIndex) titles() []string {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	var titles []string
+	for _, list := range ti.folded {
+		titles = append(titles, list...)
+	}
+	return titles
+}
+
+func (ti *titleIndex) len() int {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	n := 0
+	for _, list := range ti.folded {
+		n += len(list)
+	}
+	return n
+}
+
+// The title of the page a title refers to, if there is one
+func (ti *titleIndex) resolve(title string) (string, bool) {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	candidates := ti.folded[strings.ToLower(title)]
+	if len(candidates) == 0 {
+		return "", false
+	}
+	if slices.Contains(candidates, title) {
+		return title, true
+	}
+	return candidates[0], true
+}
+
+// The canonical title for a title, or the title itself if no page has it
+func resolveTitle(title string) string {
+	if canonical, ok := titleCases.resolve(title); ok {
+		return canonical
+	}
+	return title
+}
+
+// Build a link to a page handler, e.g. pageURL("view", "Meeting Notes") is /view/Meeting%20Notes
+func pageURL(action, title string) string {
+	return sitePath("/" + action + "/" + titlePath(title))
+}
+
+// GET /titles.json?q=meet suggests the pages whose titles start with what's
+// been typed, then those containing it, whatever the case, for the editor's
+// link button to offer. Only pages the visitor can read are suggested.
+func titlesHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.ToLower(strings.TrimSpace(r.FormValue("q")))
+	var prefixed, containing []string
+	if q != "" {
+		for _, title := range titleCases.titles() {
+			folded := strings.ToLower(title)
+			switch {
+			case strings.HasPrefix(folded, q) || strings.HasPrefix(strings.ToLower(titleName(title)), q):
+				prefixed = append(prefixed, title)
+			case strings.Contains(folded, q):
+				containing = append(containing, title)
+			}
+		}
+	}
+	slices.SortFunc(prefixed, compareTitles)
+	slices.SortFunc(containing, compareTitles)
+	suggestions := []string{}
+	for _, title := range append(prefixed, containing...) {
+		if len(suggestions) == titleSuggestions {
+			break
+		}
+		if perm, err := pagePermission(r, title); err == nil && perm >= permRead {
+			suggestions = append(suggestions, title)
+		}
+	}
+	// what's suggested depends on who's asking
+	w.Header().Set("Cache-Control", "private, no-cache")
+	writeJSON(w, http.StatusOK, suggestions)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Serve on every listen address, over HTTPS when configured to: with Let's
+// Encrypt certificates when autocert domains are set, otherwise with the given
+// certificate and key. Unix sockets are always plain HTTP, as whatever is in
+// front of them sees to TLS. Serving stops when any listener fails.
+func serve(srv *http.Server) error {
+	addrs := listenAddrs()
+	listeners := make([]net.Listener, len(addrs))
+	for i, addr := range addrs {
+		l, err := listen(addr)
+		if err != nil {
+			return err
+		}
+		listeners[i] = l
+	}
+	certFile, keyFile := config.TLSCert, config.TLSKey
+	scheme := "HTTPS"
+	switch {
+	case len(config.AutocertDomains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.AutocertDomains...),
+			Cache:      autocert.DirCache(config.AutocertCache),
+			Email:      config.AutocertEmail,
+		}
+		srv.TLSConfig = m.TLSConfig()
+
+		// answer HTTP-01 challenges and send everyone else to HTTPS
+		redirect := &http.Server{
+			Addr:         config.HTTPAddr,
+			Handler:      m.HTTPHandler(nil),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		go func() {
+			log.Fatal(redirect.ListenAndServe())
+		}()
+		scheme = fmt.Sprintf("HTTPS for %v with Let's Encrypt certificates", config.AutocertDomains)
+	case config.TLSCert == "":
+		scheme = "HTTP"
+	}
+	errs := make(chan error, len(listeners))
+	for i, l := range listeners {
+		if _, ok := unixSocket(addrs[i]); ok || scheme == "HTTP" {
+			log.Printf("Serving HTTP on %s\n", addrs[i])
+			go func(l net.Listener) { errs <- srv.Serve(l) }(l)
+			continue
+		}
+		log.Printf("Serving %s on %s\n", scheme, addrs[i])
+		go func(l net.Listener) { errs <- srv.ServeTLS(l, certFile, keyFile) }(l)
+	}
+	return <-errs
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	headingLine = regexp.MustCompile(`(?m)^(#{1,3})[ \t]+(.+?)[ \t]*\r?$`)
+	noTOC       = regexp.MustCompile(`\{\{notoc\}\}`)
+)
+
+// Pages with at least this many headings get a table of contents
+const minTOCHeadings = 3
+
+// A heading on a page, with the anchor linking to it
+type heading struct {
+	Level  int
+	Text   string
+	Anchor string
+}
+
+// Anchors are the heading's words in lower case joined by dashes
+func headingAnchor(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "section"
+	}
+	return b.String()
+}
+
+// Turn "# Heading" lines (up to ### for the third level) into headings below
+// the page title, each with an anchor, and return them in order. Anchors that
+// would repeat get a number on the end.
+func renderHeadings(escaped []byte) ([]byte, []heading) {
+	var found []heading
+	seen := make(map[string]int)
+	out := headingLine.ReplaceAllFunc(escaped, func(line []byte) []byte {
+		m := headingLine.FindSubmatch(line)
+		h := heading{Level: len(m[1]), Text: string(m[2])}
+		// the text is escaped already, so unescape it for the anchor
+		h.Anchor = headingAnchor(strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&#34;", `"`, "&#39;", "'").Replace(h.Text))
+		if seen[h.Anchor]++; seen[h.Anchor] > 1 {
+			h.Anchor += "-" + strconv.Itoa(seen[h.Anchor])
+		}
+		found = append(found, h)
+		tag := "h" + strconv.Itoa(h.Level+2)
+		return []byte(`<` + tag + ` id="` + h.Anchor + `">` + h.Text + `</` + tag + `>`)
+	})
+	return out, found
+}
+
+// Build the table of contents, indenting each heading by its level
+func renderTOC(l *locale, headings []heading) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<nav class="toc"><h5>` + template.HTMLEscapeString(l.translate("Contents")) + `</h5><ul class="no-bullet">`)
+	for _, h := range headings {
+		b.WriteString(`<li class="toc-level-` + strconv.Itoa(h.Level) + `"><a href="#` + h.Anchor + `">` + stripLinks(h.Text) + `</a></li>`)
+	}
+	b.WriteString(`</ul></nav>`)
+	return b.Bytes()
+}
+
+// Heading text may hold [[links]], which can't nest inside the contents links
+func stripLinks(text string) string {
+	return wikiLink.ReplaceAllString(text, "$1")
+}
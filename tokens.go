@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token scopes: a read token can only fetch pages, a write token can change them too
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+)
+
+// Tokens start with this, so they're easy to spot in scripts and secret scanners
+const tokenPrefix = "gowiki_"
+
+// When a token was last used is kept to the minute, so a script making many
+// requests doesn't have the tokens file rewritten for each one
+const tokenUseInterval = time.Minute
+
+// APIToken lets a script use the JSON API as its owner. Only a hash of the
+// token is kept, so it's shown once when it's created and never again.
+type APIToken struct {
+	ID       string     `json:"id"`
+	Username string     `json:"username"`
+	Name     string     `json:"name"`
+	Scope    string     `json:"scope"`
+	Hash     string     `json:"hash"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+// tokenStore keeps everyone's API tokens, saved to data/.tokens.json
+// whenever one is created or revoked, and at most once a minute as one's used
+type tokenStore struct {
+	mu     sync.Mutex
+	tokens []*APIToken
+}
+
+var tokens = &tokenStore{}
+
+func tokensFile() string {
+	return dataPath(".tokens.json")
+}
+
+func (s *tokenStore) load() error {
+	data, err := os.ReadFile(tokensFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.Unmarshal(data, &s.tokens)
+}
+
+// Write the tokens back out; callers must hold the lock
+func (s *tokenStore) persist() error {
+	data, err := json.MarshalIndent(s.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(tokensFile(), data)
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Make a new token for the user, returning it along with the secret to hand them
+func (s *tokenStore) create(username, name, scope string) (*APIToken, string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	secret := tokenPrefix + hex.EncodeToString(b)
+	t := &APIToken{ID: hex.EncodeToString(b[:4]), Username: username, Name: name, Scope: scope, Hash: hashToken(secret), Created: time.Now().UTC()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = append(s.tokens, t)
+	return t, secret, s.persist()
+}
+
+// The user's tokens, oldest first
+func (s *tokenStore) list(username string) []APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []APIToken
+	for _, t := range s.tokens {
+		if t.Username == username {
+			list = append(list, *t)
+		}
+	}
+	return list
+}
+
+// Revoke one of the user's tokens, returning it if there was one to revoke
+func (s *tokenStore) revoke(username, id string) (*APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.tokens, func(t *APIToken) bool { return t.Username == username && t.ID == id })
+	if i < 0 {
+		return nil, nil
+	}
+	t := s.tokens[i]
+	s.tokens = slices.Delete(s.tokens, i, i+1)
+	return t, s.persist()
+}
+
+// Revoke all of a user's tokens, as when their account is deleted, so they
+// can't be used by someone who takes the username later
+func (s *tokenStore) revokeAll(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := len(s.tokens)
+	s.tokens = slices.DeleteFunc(s.tokens, func(t *APIToken) bool { return t.Username == username })
+	if len(s.tokens) == before {
+		return nil
+	}
+	return s.persist()
+}
+
+// Find the token a secret belongs to, noting that it's been used
+func (s *tokenStore) use(secret string) *APIToken {
+	hash := hashToken(secret)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.Hash == hash {
+			if now := time.Now().UTC(); t.LastUsed == nil || now.Sub(*t.LastUsed) >= tokenUseInterval {
+				t.LastUsed = &now
+				if err := s.persist(); err != nil {
+					log.Printf("Couldn't save API tokens: %s\n", err.Error())
+				}
+			}
+			found := *t
+			return &found
+		}
+	}
+	return nil
+}
+
+// apiAuth lets an Authorization: Bearer token stand in for a session on the
+// JSON API, acting as the token's owner. Read tokens can't change anything,
+// and every request made with a token is audited.
+func apiAuth(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			fn(w, r)
+			return
+		}
+		secret, ok := strings.CutPrefix(header, "Bearer ")
+		t := tokens.use(strings.TrimSpace(secret))
+		var user *User
+		if ok && t != nil {
+			user = users.get(t.Username)
+		}
+		if user == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gowiki"`)
+			apiError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), userKey, user))
+		audit(r, "token", "", "used "+t.Name+": "+r.Method+" "+r.URL.Path)
+		if t.Scope != scopeWrite && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			apiError(w, http.StatusForbidden, "this token can only read")
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// /settings/tokens lists the user's API tokens, and creates and revokes them
+func tokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	data := struct {
+		Tokens  []APIToken
+		Created *APIToken
+		Secret  string
+		Error   string
+	}{}
+	if r.Method == http.MethodPost {
+		if id := r.FormValue("revoke"); id != "" {
+			t, err := tokens.revoke(user.Username, id)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			if t != nil {
+				audit(r, "token", "", "revoked "+t.Name)
+			}
+			http.Redirect(w, r, sitePath("/settings/tokens"), http.StatusFound)
+			return
+		}
+		name := strings.TrimSpace(r.FormValue("name"))
+		scope := r.FormValue("scope")
+		switch {
+		case name == "":
+			data.Error = "Give the token a name, so you can tell it apart from the others."
+		case scope != scopeRead && scope != scopeWrite:
+			data.Error = "Pick whether the token can only read pages or change them too."
+		default:
+			t, secret, err := tokens.create(user.Username, name, scope)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			audit(r, "token", "", "created "+name+" ("+scope+")")
+			data.Created, data.Secret = t, secret
+		}
+		if data.Error != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+	data.Tokens = tokens.list(user.Username)
+	renderTemplate(w, r, "tokens", data)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans for requests and the store calls under them. Until tracing is set up
+// this is OpenTelemetry's no-op tracer, so spans cost next to nothing.
+var tracer = otel.Tracer("github.com/pete-dot-m/gowiki")
+
+// Send traces to the OTLP/HTTP collector at -otlp-endpoint, such as the
+// OpenTelemetry Collector or Jaeger. A trace started by a proxy in front, in a
+// traceparent header, is carried on rather than begun afresh.
+func setupTracing() error {
+	if config.OTLPEndpoint == "" {
+		return nil
+	}
+	if config.TraceSampleRatio < 0 || config.TraceSampleRatio > 1 {
+		return fmt.Errorf("-trace-sample-ratio must be between 0 and 1, not %g", config.TraceSampleRatio)
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(config.OTLPEndpoint))
+	if err != nil {
+		return err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName("gowiki")))
+	if err != nil {
+		return err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.TraceSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.Printf("Couldn't send traces: %s\n", err.Error())
+	}))
+	tracer = provider.Tracer("github.com/pete-dot-m/gowiki")
+	log.Printf("Sending traces to %s\n", config.OTLPEndpoint)
+	return nil
+}
+
+// What a request's span is named after: the handler it's for, e.g. /view/
+// rather than each page's own path
+func traceRoute(path string) string {
+	if strings.HasPrefix(path, apiPrefix) {
+		return apiPrefix
+	}
+	if i := strings.Index(path[min(1, len(path)):], "/"); i >= 0 {
+		return path[:i+2]
+	}
+	return path
+}
+
+// tracing middleware: a span for every request, named after its method and
+// route, which the store's spans sit under
+func traceHandler(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		route := traceRoute(r.URL.Path)
+		ctx, span := tracer.Start(ctx, r.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.HTTPRoute(route),
+			semconv.URLPath(r.URL.Path),
+			semconv.ClientAddress(clientIP(r)),
+			attribute.String("gowiki.request_id", requestID(r)),
+		))
+		defer span.End()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Start a span for a call into the store, ended by the returned function
+// with the call's error, if any
+func traceStore(ctx context.Context, op, title string) (context.Context, func(error)) {
+	ctx, span := tracer.Start(ctx, "store."+op, trace.WithAttributes(
+		attribute.String("gowiki.storage", config.Storage),
+		attribute.String("gowiki.title", title),
+	))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var trashPath = regexp.MustCompile("^/trash/(restore|purge)/([0-9]+_.+)$")
+
+var errPageExists = errors.New("a page with that title already exists")
+
+// A deleted page waiting in the trash. Its ID records when it was deleted
+// alongside the title, so the same title can be trashed more than once.
+type trashEntry struct {
+	ID      string
+	Title   string
+	Deleted time.Time
+}
+
+func trashDir() string {
+	return dataPath(".trash")
+}
+
+func trashFile(entry trashEntry) string {
+	return filepath.Join(trashDir(), strconv.FormatInt(entry.Deleted.UnixNano(), 10)+"_"+titleFileName(entry.Title)+".txt")
+}
+
+func parseTrashID(id string) (trashEntry, bool) {
+	stamp, title, ok := strings.Cut(id, "_")
+	if !ok || !validTitle(title) {
+		return trashEntry{}, false
+	}
+	nanos, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil {
+		return trashEntry{}, false
+	}
+	return trashEntry{ID: id, Title: title, Deleted: time.Unix(0, nanos)}, true
+}
+
+// Keep a copy of the page in the trash, then delete it from the store. The
+// copy is made first so the page is never lost between the two, and taken
+// out again if the page couldn't be deleted, so it isn't in both places.
+func trashPage(ctx context.Context, title string, edit Edit) error {
+	p, err := loadPage(ctx, title)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(trashDir(), os.ModePerm); err != nil {
+		return err
+	}
+	entry := trashEntry{Title: title, Deleted: time.Now().UTC()}
+	if err := os.WriteFile(trashFile(entry), p.Body, 0600); err != nil {
+		return err
+	}
+	ctx, end := traceStore(ctx, "Delete", title)
+	err = store.Delete(ctx, title, edit)
+	end(err)
+	if err != nil {
+		return errors.Join(err, os.Remove(trashFile(entry)))
+	}
+	return nil
+}
+
+// List the trash, most recently deleted first
+func listTrash() ([]trashEntry, error) {
+	files, err := os.ReadDir(trashDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []trashEntry
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".txt")
+		if !ok {
+			continue
+		}
+		stamp, fileName, _ := strings.Cut(name, "_")
+		title, ok := titleFromFileName(fileName)
+		if !ok {
+			continue
+		}
+		if entry, ok := parseTrashID(stamp + "_" + title); ok {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Deleted.After(entries[j].Deleted) })
+	return entries, nil
+}
+
+// Restoring saves the trashed copy as a new revision of the page
+func restoreFromTrash(ctx context.Context, entry trashEntry, edit Edit) error {
+	if pageExists(entry.Title) {
+		return errPageExists
+	}
+	body, err := os.ReadFile(trashFile(entry))
+	if err != nil {
+		return err
+	}
+	p := &Page{Title: entry.Title, Body: body}
+	if err := p.save(ctx, edit); err != nil {
+		return err
+	}
+	return os.Remove(trashFile(entry))
+}
+
+// Permanently delete a trashed page. Once nothing is left of the page, its
+// permissions, drafts, discussion, watchers and view count go too, along with its history if the store can forget it.
+// The git store can't, so there the page's commits stay in the repository.
+func purgeFromTrash(entry trashEntry) error {
+	if err := os.Remove(trashFile(entry)); err != nil {
+		return err
+	}
+	if pageExists(entry.Title) {
+		return nil
+	}
+	entries, err := listTrash()
+	if err != nil {
+		return err
+	}
+	for _, other := range entries {
+		if other.Title == entry.Title {
+			return nil
+		}
+	}
+	if purger, ok := store.(historyPurger); ok {
+		if err := purger.PurgeHistory(entry.Title); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+	if err := os.Remove(aclFile(entry.Title)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(talkFile(entry.Title)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := watches.forget(entry.Title); err != nil {
+		return err
+	}
+	views.remove(entry.Title)
+	return os.RemoveAll(filepath.Dir(draftFile(entry.Title, "")))
+}
+
+// GET asks for confirmation, POST moves the page to the trash
+func deleteHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if !pageExists(title) {
+		notFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		renderTemplate(w, r, "delete", &Page{Title: title})
+		return
+	}
+	if err := deletePage(r.Context(), title, newEdit(r, "Deleted "+title)); err != nil {
+		serverError(w, r, err)
+		return
+	}
+	audit(r, "delete", title, "")
+	http.Redirect(w, r, sitePath("/"), http.StatusFound)
+}
+
+func trashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/trash" || r.URL.Path == "/trash/" {
+		entries, err := listTrash()
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		renderTemplate(w, r, "trash", entries)
+		return
+	}
+
+	m := trashPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		notFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "That page can only be reached by submitting a form.")
+		return
+	}
+	entry, ok := parseTrashID(m[2])
+	if !ok {
+		notFound(w, r)
+		return
+	}
+
+	var err error
+	action := "undelete"
+	if m[1] == "restore" {
+		err = restoreFromTrash(r.Context(), entry, newEdit(r, "Restored from the trash"))
+	} else {
+		err = purgeFromTrash(entry)
+		action = "purge"
+	}
+	if err == nil {
+		audit(r, action, entry.Title, "deleted "+entry.Deleted.UTC().Format("2006-01-02 15:04:05 MST"))
+	}
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		notFound(w, r)
+	case errors.Is(err, errPageExists):
+		httpError(w, r, http.StatusConflict, "A page called "+entry.Title+" exists again, so the trashed copy can't be restored over it.")
+	case err != nil:
+		serverError(w, r, err)
+	default:
+		http.Redirect(w, r, sitePath("/trash"), http.StatusFound)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How many pages /popular lists
+const popularLimit = 50
+
+// How often view counts are written out; a crash loses at most this much counting
+const viewFlushInterval = 30 * time.Second
+
+// viewCounter counts page views in memory and saves them to
+// data/.views.json every so often rather than writing on every view
+type viewCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+	dirty  bool
+}
+
+var views = &viewCounter{counts: make(map[string]int)}
+
+func viewsFile() string {
+	return dataPath(".views.json")
+}
+
+func (v *viewCounter) load() error {
+	data, err := os.ReadFile(viewsFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return json.Unmarshal(data, &v.counts)
+}
+
+func (v *viewCounter) flush() error {
+	v.mu.Lock()
+	if !v.dirty {
+		v.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(v.counts)
+	v.dirty = false
+	v.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(viewsFile(), data, 0600)
+}
+
+// Keep saving the counts in the background
+func (v *viewCounter) flushEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := v.flush(); err != nil {
+			log.Printf("Couldn't save view counts: %s\n", err.Error())
+		}
+	}
+}
+
+// Count a view, returning the page's new total
+func (v *viewCounter) add(title string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.counts[title]++
+	v.dirty = true
+	return v.counts[title]
+}
+
+func (v *viewCounter) remove(title string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.counts, title)
+	v.dirty = true
+}
+
+type pageViews struct {
+	Title string
+	Views int
+}
+
+// The given pages by how often they've been viewed, most first, leaving out unviewed ones
+func (v *viewCounter) popular(titles []string, limit int) []pageViews {
+	v.mu.Lock()
+	var ranked []pageViews
+	for _, title := range titles {
+		if n := v.counts[title]; n > 0 {
+			ranked = append(ranked, pageViews{title, n})
+		}
+	}
+	v.mu.Unlock()
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Views != ranked[j].Views {
+			return ranked[i].Views > ranked[j].Views
+		}
+		return ranked[i].Title < ranked[j].Title
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+func popularHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := store.List()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "popular", views.popular(readableTitles(r, titles), popularLimit))
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// How many notifications are kept for each user, newest first
+const notificationLimit = 200
+
+// watchStore remembers which pages each user watches, saved to
+// data/.watches.json whenever someone starts or stops watching
+type watchStore struct {
+	mu      sync.Mutex
+	watches map[string][]string // username to titles
+}
+
+var watches = &watchStore{watches: make(map[string][]string)}
+
+func watchesFile() string {
+	return dataPath(".watches.json")
+}
+
+func (s *watchStore) load() error {
+	data, err := os.ReadFile(watchesFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.Unmarshal(data, &s.watches)
+}
+
+// Write the watches back out; callers must hold the lock
+func (s *watchStore) persist() error {
+	data, err := json.MarshalIndent(s.watches, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(watchesFile(), data, 0600)
+}
+
+func (s *watchStore) watching(user, title string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Contains(s.watches[user], title)
+}
+
+func (s *watchStore) set(user, title string, watch bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	titles := s.watches[user]
+	i, found := slices.BinarySearch(titles, title)
+	switch {
+	case watch && !found:
+		s.watches[user] = slices.Insert(titles, i, title)
+	case !watch && found:
+		if titles = slices.Delete(titles, i, i+1); len(titles) == 0 {
+			delete(s.watches, user)
+		} else {
+			s.watches[user] = titles
+		}
+	default:
+		return nil
+	}
+	return s.persist()
+}
+
+// Stop everyone watching a page that's gone for good
+func (s *watchStore) forget(title string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for user, titles := range s.watches {
+		if i, found := slices.BinarySearch(titles, title); found {
+			if titles = slices.Delete(titles, i, i+1); len(titles) == 0 {
+				delete(s.watches, user)
+			} else {
+				s.watches[user] = titles
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.persist()
+}
+
+// Everyone watching the page
+func (s *watchStore) watchers(title string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []string
+	for user, titles := range s.watches {
+		if _, found := slices.BinarySearch(titles, title); found {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// A Notification tells a user that a page they watch has changed
+type Notification struct {
+	Time     time.Time `json:"time"`
+	Title    string    `json:"title"`
+	Revision int       `json:"revision"`
+	Author   string    `json:"author,omitempty"`
+	Summary  string    `json:"summary,omitempty"`
+	Read     bool      `json:"read,omitempty"`
+}
+
+// Each user's notifications live in their own file under data/.notifications.
+// The lock stops a new notification racing with marking them read.
+var notificationsMu sync.Mutex
+
+func notificationsFile(user string) string {
+	return filepath.Join(dataPath(".notifications"), user+".json")
+}
+
+func loadNotifications(user string) ([]Notification, error) {
+	data, err := os.ReadFile(notificationsFile(user))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Notification
+	return list, json.Unmarshal(data, &list)
+}
+
+func saveNotifications(user string, list []Notification) error {
+	if err := os.MkdirAll(filepath.Dir(notificationsFile(user)), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(notificationsFile(user), data, 0600)
+}
+
+func addNotification(user string, n Notification) error {
+	notificationsMu.Lock()
+	defer notificationsMu.Unlock()
+	list, err := loadNotifications(user)
+	if err != nil {
+		return err
+	}
+	list = append([]Notification{n}, list...)
+	return saveNotifications(user, list[:min(len(list), notificationLimit)])
+}
+
+// Tell everyone watching the page about a change, except whoever made it,
+// emailing those who asked for it. Watchers who can't read the page any more,
+// say since it was restricted, aren't told.
+// A failure is logged rather than failing the save, which has already happened.
+func notifyWatchers(c Change) {
+	for _, user := range watches.watchers(c.Title) {
+		if user == c.Author {
+			continue
+		}
+		u := users.get(user)
+		if u == nil {
+			continue
+		}
+		if perm, err := userPermission(u, c.Title); err != nil || perm < permRead {
+			continue
+		}
+		n := Notification{Time: c.Time, Title: c.Title, Revision: c.Revision, Author: c.Author, Summary: c.Summary}
+		if err := addNotification(user, n); err != nil {
+			log.Printf("Couldn't notify %s of a change to %s: %s\n", user, c.Title, err.Error())
+		}
+		if u.NotifyByEmail {
+			sendMail(u.Email, userLocale(u), "page-changed", map[string]any{"User": user, "Change": c,
+				"PageURL": pageURL("view", c.Title), "HistoryURL": pageURL("history", c.Title)})
+		}
+	}
+}
+
+// POST /watch/<title> starts watching the page, or stops with unwatch=1
+func watchHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "That page can only be reached by submitting a form.")
+		return
+	}
+	if err := watches.set(username(r), title, r.FormValue("unwatch") == ""); err != nil {
+		serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, pageURL("view", title), http.StatusFound)
+}
+
+// GET lists the user's notifications, and POST marks them all read
+func notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	user := username(r)
+	notificationsMu.Lock()
+	defer notificationsMu.Unlock()
+	list, err := loadNotifications(user)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if r.Method == http.MethodPost {
+		for i := range list {
+			list[i].Read = true
+		}
+		if err := saveNotifications(user, list); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, sitePath("/notifications"), http.StatusFound)
+		return
+	}
+	list = readableNotifications(r, list)
+	unread := 0
+	for _, n := range list {
+		if !n.Read {
+			unread++
+		}
+	}
+	renderTemplate(w, r, "notifications", struct {
+		Notifications []Notification
+		Unread        int
+	}{list, unread})
+}
+
+// Drop notifications about pages the user can no longer read
+func readableNotifications(r *http.Request, list []Notification) []Notification {
+	var readable []Notification
+	for _, n := range list {
+		if have, err := pagePermission(r, n.Title); err == nil && have >= permRead {
+			readable = append(readable, n)
+		}
+	}
+	return readable
+}
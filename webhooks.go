@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Page events webhooks can be sent for
+const (
+	eventCreated = "created"
+	eventEdited  = "edited"
+	eventDeleted = "deleted"
+)
+
+// How many deliveries can wait to be sent before new ones are dropped, and
+// how many recent ones the admin page shows
+const (
+	webhookQueueSize = 100
+	webhookLogSize   = 100
+)
+
+// A failed delivery is retried this many times, waiting twice as long each time
+const (
+	webhookAttempts   = 5
+	webhookRetryDelay = 30 * time.Second
+)
+
+// Webhook is an external URL told about page events. With a secret, each
+// request is signed: X-Gowiki-Signature holds the hex HMAC-SHA256 of the body.
+type Webhook struct {
+	URL    string   `yaml:"url"`
+	Events []string `yaml:"events"` // empty for every event
+	Secret string   `yaml:"secret"`
+}
+
+func (h Webhook) wants(event string) bool {
+	return len(h.Events) == 0 || slices.Contains(h.Events, event)
+}
+
+// The JSON posted to a webhook
+type webhookEvent struct {
+	Event    string    `json:"event"`
+	Title    string    `json:"title"`
+	Author   string    `json:"author,omitempty"`
+	Revision int       `json:"revision,omitempty"`
+	Summary  string    `json:"summary,omitempty"`
+	Time     time.Time `json:"time"`
+	URL      string    `json:"url"`
+	DiffURL  string    `json:"diff_url,omitempty"`
+}
+
+// A delivery of one event to one webhook, kept for the admin page. Chat
+// notifications go out the same way, with a message of their own.
+type webhookDelivery struct {
+	Target   string // where it's going, as the admin page shows it
+	Event    webhookEvent
+	url      string
+	secret   string
+	payload  any
+	Attempts int
+	Status   int // the last response's status, if there was one
+	Error    string
+	Done     bool
+	Updated  time.Time
+}
+
+var (
+	webhookQueue  = make(chan *webhookDelivery, webhookQueueSize)
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+	webhookLogMu  sync.Mutex
+	webhookLog    []*webhookDelivery // newest first
+	webhookEvents = []string{eventCreated, eventEdited, eventDeleted}
+)
+
+// Check each webhook has a URL it can post to and only asks for events there are
+func checkWebhooks(hooks []Webhook) error {
+	for _, h := range hooks {
+		if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook URL %q must be an http or https URL", h.URL)
+		}
+		for _, event := range h.Events {
+			if !slices.Contains(webhookEvents, event) {
+				return fmt.Errorf("webhook %s asks for %q, but the events are created, edited and deleted", h.URL, event)
+			}
+		}
+	}
+	return nil
+}
+
+// Queue the change for every webhook wanting the event. Like mail, webhooks
+// are best effort: with a full queue the delivery is logged and dropped.
+func sendWebhooks(event string, c Change) {
+	e := webhookEvent{Event: event, Title: c.Title, Author: c.Author, Revision: c.Revision, Summary: c.Summary, Time: c.Time,
+		URL: siteURL() + pageURL("view", c.Title)}
+	if event == eventEdited && c.Revision > 1 {
+		e.DiffURL = siteURL() + pageURL("diff", c.Title) + "/" + strconv.Itoa(c.Previous()) + "/" + strconv.Itoa(c.Revision)
+	}
+	for _, h := range config.Webhooks {
+		if !h.wants(event) {
+			continue
+		}
+		queueDelivery(&webhookDelivery{Target: h.URL, Event: e, url: h.URL, secret: h.Secret, payload: e})
+	}
+}
+
+// Note a new delivery for the admin page and queue it
+func queueDelivery(d *webhookDelivery) {
+	d.Updated = time.Now().UTC()
+	webhookLogMu.Lock()
+	webhookLog = append([]*webhookDelivery{d}, webhookLog[:min(len(webhookLog), webhookLogSize-1)]...)
+	webhookLogMu.Unlock()
+	enqueueWebhook(d)
+}
+
+func enqueueWebhook(d *webhookDelivery) {
+	select {
+	case webhookQueue <- d:
+	default:
+		log.Printf("Webhook queue is full, dropping %s of %s to %s\n", d.Event.Event, d.Event.Title, d.Target)
+		d.record(0, "dropped: the queue was full", true)
+	}
+}
+
+func (d *webhookDelivery) record(status int, errText string, done bool) {
+	webhookLogMu.Lock()
+	defer webhookLogMu.Unlock()
+	d.Status, d.Error, d.Done, d.Updated = status, errText, done, time.Now().UTC()
+}
+
+// Post queued deliveries one at a time, putting failures back on the queue after a wait
+func runWebhooks() {
+	for d := range webhookQueue {
+		status, err := postWebhook(d)
+		webhookLogMu.Lock()
+		d.Attempts++
+		attempts := d.Attempts
+		webhookLogMu.Unlock()
+		if err == nil {
+			d.record(status, "", true)
+			continue
+		}
+		if attempts >= webhookAttempts {
+			log.Printf("Giving up on %s of %s to %s after %d attempts: %s\n", d.Event.Event, d.Event.Title, d.Target, attempts, err.Error())
+			d.record(status, err.Error(), true)
+			continue
+		}
+		delay := webhookRetryDelay << (attempts - 1)
+		log.Printf("Couldn't post %s of %s to %s, retrying in %s: %s\n", d.Event.Event, d.Event.Title, d.Target, delay, err.Error())
+		d.record(status, err.Error(), false)
+		time.AfterFunc(delay, func() { enqueueWebhook(d) })
+	}
+}
+
+// Post the event, returning the response's status. Anything but a 2xx is a failure.
+func postWebhook(d *webhookDelivery) (int, error) {
+	body, err := json.Marshal(d.payload)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gowiki")
+	req.Header.Set("X-Gowiki-Event", d.Event.Event)
+	if d.secret != "" {
+		mac := hmac.New(sha256.New, []byte(d.secret))
+		mac.Write(body)
+		req.Header.Set("X-Gowiki-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("the webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// /admin/webhooks shows the configured webhooks and how recent deliveries went
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	var chats []string
+	for _, n := range config.ChatNotifiers {
+		chats = append(chats, n.target())
+	}
+	webhookLogMu.Lock()
+	deliveries := make([]webhookDelivery, len(webhookLog))
+	for i, d := range webhookLog {
+		deliveries[i] = *d
+	}
+	webhookLogMu.Unlock()
+	renderTemplate(w, r, "webhooks", struct {
+		Hooks      []Webhook
+		Chats      []string
+		Deliveries []webhookDelivery
+	}{config.Webhooks, chats, deliveries})
+}
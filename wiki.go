@@ -1,164 +1,630 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"embed"
 	"errors"
+	"expvar"
+	"flag"
 	"html/template"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Page struct {
 	Title string
 	Body  []byte
+	Meta  PageMeta
+	// when the page was last saved, and by whom if anyone was logged in
+	ModTime    time.Time
+	LastEditor string
+}
+
+// Longest edit summary kept, in runes
+const maxSummaryLength = 200
+
+// An Edit says who saved a page and why. It's recorded with the revision it creates.
+type Edit struct {
+	Author  string // empty for anonymous edits
+	Summary string
+}
+
+// Build the edit for a request, tidying the summary onto one line
+func newEdit(r *http.Request, summary string) Edit {
+	summary = strings.Join(strings.Fields(summary), " ")
+	if runes := []rune(summary); len(runes) > maxSummaryLength {
+		summary = string(runes[:maxSummaryLength])
+	}
+	return Edit{Author: username(r), Summary: summary}
 }
 
 var (
-	templates = template.Must(template.ParseFiles("templates/index.html", "templates/edit.html", "templates/view.html"))
-	validPath = regexp.MustCompile("^/(edit|save|view)/([a-zA-Z0-9]+)$")
+	templateSets sync.Map // *template.Template by locale and time zone, parsed as they're first needed
+	validPath    = regexp.MustCompile("^/(edit|save|preview|view|history|backlinks|delete|upload|tag|draft|live|watch|talk|comment|raw|print)/(.+)$")
 )
 
-// Helper to load page files from the data directory, creating it if it doesn't exist
-func getDataFileNames(path string) ([]string, error) {
-	var fileNames []string
+// Page load and save functions
+// Saving records the edit as a new revision
+// notSavedError is a save the store couldn't make, so the page is as it was
+type notSavedError struct {
+	err error
+}
 
-	// check that the directory exists, create it if not...
-	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
-		err := os.Mkdir(path, os.ModePerm)
-		if err != nil {
-			log.Printf("Directory %s doesn't exist and couldn't create\n", path)
-			return fileNames, err
-		}
-	}
+func (e *notSavedError) Error() string {
+	return "the page wasn't saved: " + e.err.Error()
+}
+
+func (e *notSavedError) Unwrap() error {
+	return e.err
+}
 
-	// open the directory and get the files
-	f, err := os.Open(path)
+func (p *Page) save(ctx context.Context, edit Edit) error {
+	if config.MaxPageBytes > 0 && int64(len(p.Body)) > config.MaxPageBytes {
+		return errPageTooLarge
+	}
+	if err := stampFrontMatter(p, edit.Author, time.Now()); err != nil {
+		return err
+	}
+	event := eventEdited
+	if !pageExists(p.Title) {
+		event = eventCreated
+	}
+	ctx, end := traceStore(ctx, "Save", p.Title)
+	rev, err := store.Save(ctx, p, edit)
+	// saving what's already there is fine, but there's no change to tell anyone of
+	if errors.Is(err, errUnchanged) {
+		end(nil)
+		p.ModTime, p.LastEditor = lastEdits.lookup(p.Title)
+		return nil
+	}
+	end(err)
 	if err != nil {
-		log.Printf("Couldn't open directory %s: %s\n", path, err.Error())
-		return fileNames, err
+		return &notSavedError{err}
+	}
+	lastEdits.set(p.Title, *rev)
+	p.ModTime, p.LastEditor = rev.Time, rev.Author
+	indexPage(p.Title, p.Body)
+	change := Change{Title: p.Title, Revision: rev.Number, Time: rev.Time, Author: edit.Author, Summary: edit.Summary}
+	// the page is saved by now, so a change log that can't be written is only
+	// logged, and the people waiting on the edit still hear of it
+	if err := recordChange(change); err != nil {
+		log.Printf("Couldn't record the change to %s: %s\n", p.Title, err.Error())
 	}
-	files, err := f.Readdir(-1)
+	notifyWatchers(change)
+	sendWebhooks(event, change)
+	notifyChat(event, change, p.Body)
+	return nil
+}
+
+func loadPage(ctx context.Context, title string) (*Page, error) {
+	ctx, end := traceStore(ctx, "Load", title)
+	p, err := store.Load(ctx, title)
+	end(err)
 	if err != nil {
-		log.Printf("Couldn't read directory %s: %s\n", path, err.Error())
-		return fileNames, err
+		return nil, err
+	}
+	// front matter that doesn't parse is just left out
+	p.Meta, _, _ = parseFrontMatter(p.Body)
+	p.ModTime, p.LastEditor = lastEdits.lookup(title)
+	return p, nil
+}
+
+func pageExists(title string) bool {
+	return store.Exists(title)
+}
+
+// Deleted pages go to the trash. Their revisions are kept, so saving the
+// page again picks up where it left off.
+func deletePage(ctx context.Context, title string, edit Edit) error {
+	if err := trashPage(ctx, title, edit); err != nil {
+		return err
 	}
+	unindexPage(title)
+	sendWebhooks(eventDeleted, Change{Title: title, Time: time.Now().UTC(), Author: edit.Author, Summary: edit.Summary})
+	return nil
+}
+
+// Template helpers
+// The templates are built in, so the binary runs from anywhere. Files in
+// the templates directory, if one is set, take the place of the built-in ones.
+//
+//go:embed templates
+var embeddedTemplates embed.FS
 
-	for _, file := range files {
-		name, _ := strings.CutSuffix(file.Name(), ".txt")
-		fileNames = append(fileNames, name)
+func templateFS() fs.FS {
+	base, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		// the embed directive guarantees the directory exists
+		panic(err)
 	}
-	return fileNames, nil
+	files := overlayFS{base: base}
+	if config.TemplateDir != "" {
+		files.override = os.DirFS(config.TemplateDir)
+	}
+	return files
 }
 
-// Page load and save functions
-func (p *Page) save() error {
-	filename := "data/" + p.Title + ".txt"
-	return os.WriteFile(filename, p.Body, 0600)
+// The templates for one locale, whose t function translates into it, showing
+// times in one zone
+func parseTemplates(l *locale, zone *time.Location) (*template.Template, error) {
+	return template.New("wiki").Funcs(template.FuncMap{"base": basePath, "themeColor": themeColor, "locales": listLocales, "size": roughSize}).Funcs(l.funcs()).Funcs(zoneFuncs(l, zone)).ParseFS(templateFS(), "*.html")
 }
 
-func loadPage(title string) (*Page, error) {
-	filename := "data/" + title + ".txt"
-	body, err := os.ReadFile(filename)
+// Load the catalogs, then a set of templates for each in the site's zone.
+// Sets for zones users have picked are parsed when they're first shown.
+func loadTemplates() error {
+	if err := loadLocales(); err != nil {
+		return err
+	}
+	templateSets.Range(func(key, _ any) bool {
+		templateSets.Delete(key)
+		return true
+	})
+	for _, l := range locales {
+		if _, err := currentTemplates(l, siteZone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// In dev mode templates and their catalog are re-read on every render so
+// edits show up without a restart
+func currentTemplates(l *locale, zone *time.Location) (*template.Template, error) {
+	if config.Dev {
+		fresh, err := readLocale(templateFS(), l.Code)
+		if err != nil {
+			return nil, err
+		}
+		return parseTemplates(fresh, zone)
+	}
+	key := l.Code + " " + zone.String()
+	if t, ok := templateSets.Load(key); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := parseTemplates(l, zone)
 	if err != nil {
 		return nil, err
 	}
-	return &Page{Title: title, Body: body}, nil
+	actual, _ := templateSets.LoadOrStore(key, t)
+	return actual.(*template.Template), nil
 }
 
-// Template helpers
-func renderTemplate(w http.ResponseWriter, tmpl string, p *Page) {
-	if err := templates.ExecuteTemplate(w, tmpl+".html", p); err != nil {
+// Buffers pages are rendered into, kept for the next render rather than
+// grown afresh for every request
+var renderBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// Buffers bigger than this, from the odd huge page, aren't worth holding on to
+const maxPooledBuffer = 1 << 20
+
+// Render into a buffer, then write the page in one go, so a template that
+// fails halfway leaves an error rather than half a page
+func renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data any) {
+	t, err := currentTemplates(requestLocale(r), requestZone(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	buf := renderBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			renderBuffers.Put(buf)
+		}
+	}()
+	if err := t.ExecuteTemplate(buf, tmpl+".html", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Write(buf.Bytes())
 }
 
 // The HttpHandler funcs
+// What the view page shows around the page itself
+type viewData struct {
+	*Page
+	HTML        template.HTML // rendered once, standing in for Page.HTML
+	Breadcrumbs []titlePart
+	Children    []titlePart
+	Backlinks   []string
+	Views       int
+	Watching    bool
+	Comments    int
+	System      bool
+	Sidebar     template.HTML
+	// the redirect that led here, or whether this page is a redirect that leads nowhere
+	RedirectedFrom string
+	BrokenRedirect bool
+}
+
+// Redirect pages are followed to where they lead, unless asked for with ?redirect=no
 func viewHandler(w http.ResponseWriter, r *http.Request, title string) {
-	p, err := loadPage(title)
+	follow := r.FormValue("redirect") != "no" && redirects.target(title) != ""
+	if follow {
+		if target, ok := resolveRedirect(title); ok {
+			http.Redirect(w, r, pageURL("view", target)+"?from="+url.QueryEscape(title), http.StatusFound)
+			return
+		}
+	}
+	p, err := loadPage(r.Context(), title)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Redirect(w, r, pageURL("edit", title), http.StatusFound)
+		return
+	}
 	if err != nil {
-		http.Redirect(w, r, "/edit/"+title, http.StatusFound)
+		serverError(w, r, err)
+		return
+	}
+	l := requestLocale(r)
+	data := viewData{Page: p, HTML: p.HTML(l), Breadcrumbs: breadcrumbs(title), Backlinks: readableTitles(r, links.backlinks(title)),
+		Watching: watches.watching(username(r), title), Comments: commentCount(title), System: systemPage(title), Sidebar: sidebar.get(l), BrokenRedirect: follow}
+	if data.Children, err = namespaceChildren(r, title); err != nil {
+		serverError(w, r, err)
 		return
 	}
-	renderTemplate(w, "view", p)
+	// only mention a redirect that really does lead here
+	if from := r.FormValue("from"); validTitle(from) && redirects.target(from) != "" {
+		data.RedirectedFrom = from
+	}
+	// a revalidated view still counts
+	data.Views = views.add(title)
+	if checkNotModified(w, r, viewETag(username(r), requestLocale(r).Code, requestZone(r).String(), data)) {
+		return
+	}
+	renderTemplate(w, r, "view", data)
 }
 
+// What the edit form shows: the page being edited, plus a rendering of the
+// submitted body when previewing, or the user's unsaved draft if they have one.
+// New pages also get the page types they can start from, and everyone
+// sees who else has the page open, and admins are reminded when it's a system page.
+type editData struct {
+	*Page
+	Summary string
+	Preview template.HTML
+	Draft   *Draft
+	Types   []pageType
+	Editors []string
+	System  bool
+	// why the last save failed, with the text that wasn't saved back in the editor
+	SaveError string
+	// anonymous visitors get the spam checks' hidden fields
+	Anonymous bool
+	Started   string
+	Captcha   template.HTML
+}
+
+// Show the edit form, with the spam checks' fields for anonymous visitors
+func renderEditor(w http.ResponseWriter, r *http.Request, data editData) {
+	if currentUser(r) == nil {
+		data.Anonymous, data.Started = true, startedToken()
+		data.Captcha = captchaWidget(r, captchaEdit)
+	}
+	renderTemplate(w, r, "edit", data)
+}
+
+// Editing with ?draft=restore picks up the user's draft in place of the saved
+// page, and a new page can be started from a page type with ?type=name
 func editHandler(w http.ResponseWriter, r *http.Request, title string) {
-	p, err := loadPage(title)
+	p, err := loadPage(r.Context(), title)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		// starting the page afresh would lose what's there
+		serverError(w, r, err)
+		return
+	}
+	data := editData{Page: p, Editors: editing.others(title, username(r)), System: systemPage(title)}
 	if err != nil {
 		p = &Page{Title: title}
+		if name := r.FormValue("type"); name != "" {
+			if p.Body, err = loadPageType(name); err != nil {
+				httpError(w, r, http.StatusBadRequest, "There's no page type called "+name+".")
+				return
+			}
+		}
+		data.Page = p
+		if data.Types, err = listPageTypes(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+	}
+	if draft, err := loadDraft(title, username(r)); err == nil && draft.Body != string(p.Body) {
+		if r.FormValue("draft") == "restore" {
+			data.Page = &Page{Title: title, Body: []byte(draft.Body)}
+			data.Summary = draft.Summary
+		} else {
+			data.Draft = draft
+		}
+	}
+	renderEditor(w, r, data)
+}
+
+// Previewing renders the submitted body back into the edit form without saving it
+func previewHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, pageURL("edit", title), http.StatusFound)
+		return
 	}
-	renderTemplate(w, "edit", p)
+	p := &Page{Title: title, Body: []byte(r.FormValue("body"))}
+	renderEditor(w, r, editData{Page: p, Summary: r.FormValue("summary"), Preview: p.HTML(requestLocale(r)), System: systemPage(title)})
 }
 
 func saveHandler(w http.ResponseWriter, r *http.Request, title string) {
 	body := r.FormValue("body")
+	if _, _, err := parseFrontMatter([]byte(body)); err != nil {
+		httpError(w, r, http.StatusBadRequest, "The page wasn't saved: "+err.Error()+".")
+		return
+	}
+	if currentUser(r) == nil {
+		if reason := checkSpam(r, title, []byte(body)); reason != "" {
+			w.WriteHeader(http.StatusForbidden)
+			renderEditor(w, r, editData{Page: &Page{Title: title, Body: []byte(body)}, Summary: r.FormValue("summary"),
+				System: systemPage(title), SaveError: "it looked like spam, because it " + reason})
+			return
+		}
+		if err := checkCaptchaAnswer(r, captchaEdit); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			renderEditor(w, r, editData{Page: &Page{Title: title, Body: []byte(body)}, Summary: r.FormValue("summary"),
+				System: systemPage(title), SaveError: err.Error()})
+			return
+		}
+	}
 	p := &Page{Title: title, Body: []byte(body)}
-	err := p.save()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	edit := newEdit(r, r.FormValue("summary"))
+	if err := p.save(r.Context(), edit); errors.Is(err, errPageTooLarge) {
+		pageTooLarge(w, r)
 		return
+	} else if err != nil {
+		var notSaved *notSavedError
+		if !errors.As(err, &notSaved) {
+			serverError(w, r, err)
+			return
+		}
+		// hand the text back rather than lose it
+		log.Printf("Couldn't save %s: %s\n", title, notSaved.err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		renderEditor(w, r, editData{Page: &Page{Title: title, Body: []byte(body)}, Summary: r.FormValue("summary"),
+			System: systemPage(title), SaveError: notSaved.err.Error()})
+		return
+	}
+	audit(r, "save", title, edit.Summary)
+	if err := discardDraft(title, username(r)); err != nil {
+		log.Printf("Couldn't discard draft of %s: %s\n", title, err.Error())
 	}
-	http.Redirect(w, r, "/view/"+title, http.StatusFound)
+	http.Redirect(w, r, pageURL("view", title), http.StatusFound)
 }
 
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	files, err := getDataFileNames("data")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// / shows the home page like any other view, or the contents until there is one
+func homeHandler(w http.ResponseWriter, r *http.Request) {
+	// every path nothing else handles ends up here
+	if r.URL.Path != "/" {
+		notFound(w, r)
 		return
 	}
-	if err = templates.ExecuteTemplate(w, "index.html", files); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if home := resolveTitle(homePage()); home != "" && pageExists(home) {
+		requirePermission(permRead, viewHandler)(w, r, home)
+		return
 	}
+	indexHandler(w, r)
 }
 
-// logging middleware
-func logRequestHandler(h http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		// call the original handler we're wrapping
-		h.ServeHTTP(w, r)
-
-		// gather information about the request and log it
-		uri := r.URL.String()
-		method := r.Method
-
-		log.Printf("%s:%s", uri, method)
+// /index lists every page, or with ?prefix= just those whose titles start with it
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	contents, counts, err := listContents(r)
+	if err != nil {
+		serverError(w, r, err)
+		return
 	}
-	return http.HandlerFunc(fn)
+	renderTemplate(w, r, "index", struct {
+		Contents contentsPage
+		Tags     []tagCount
+		Themes   []string
+		Theme    string
+	}{contents, tagCloud(counts), listThemes(), currentTheme(r)})
 }
 
 // HttpHandler wrapper to ensure valid paths are being passed into our handlers
 func makeHandler(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		m := validPath.FindStringSubmatch(r.URL.Path)
-		if m == nil {
-			http.NotFound(w, r)
+		if m == nil || !validTitle(m[2]) {
+			notFound(w, r)
 			return
 		}
+		// a title in the wrong case leads to the page's own, except for tags
+		// and the live preview's socket
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) && m[1] != "tag" && m[1] != "live" {
+			if canonical, ok := titleCases.resolve(m[2]); ok && canonical != m[2] {
+				target := pageURL(m[1], canonical)
+				if r.URL.RawQuery != "" {
+					target += "?" + r.URL.RawQuery
+				}
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+				return
+			}
+		}
+		// the live preview's socket stays open for as long as the editor does
+		if m[1] != "live" {
+			var cancel context.CancelFunc
+			r, cancel = timeLimited(r)
+			defer cancel()
+		}
 		fn(w, r, m[2])
 	}
 }
 
 // Where all the magic happens...
-func main() {
+// gowiki serve runs the wiki, and is what gowiki does with no subcommand given
+func serveCommand(args []string) error {
+	if err := loadConfig(flag.NewFlagSet("gowiki serve", flag.ContinueOnError), args); err != nil {
+		return err
+	}
+	if err := loadTemplates(); err != nil {
+		log.Fatalf("Couldn't load templates: %s\n", err.Error())
+	}
+	if err := loadMailTemplates(); err != nil {
+		log.Fatalf("Couldn't load mail templates: %s\n", err.Error())
+	}
+	go runMailer()
+	go runWebhooks()
+	if config.Dev {
+		log.Printf("Development mode: templates are reloaded on every request\n")
+	}
+	if !slices.Contains(listThemes(), config.Theme) {
+		log.Fatalf("There's no theme called %s in static/themes\n", config.Theme)
+	}
+	if err := loadSettings(); err != nil {
+		log.Fatalf("Couldn't load the settings saved from /admin: %s\n", err.Error())
+	}
+	readOnly.Store(config.ReadOnly)
+	if config.ReadOnly {
+		log.Printf("Read-only mode: pages can't be changed\n")
+	}
+	if err := openStore(); err != nil {
+		log.Fatalf("Couldn't open %s storage in %s: %s\n", config.Storage, config.DataDir, err.Error())
+	}
+	renders = newRenderCache(config.RenderCacheSize)
+	writeLimiter = newRateLimiter(config.WriteRateLimit, config.WriteBurst)
+	// the wiki is served while the indexes are built
+	indexer.start()
+	if err := watches.load(); err != nil {
+		log.Fatalf("Couldn't load watchlists: %s\n", err.Error())
+	}
+	if err := openSessions(); err != nil {
+		log.Fatalf("Couldn't set up the %s session store: %s\n", config.SessionStore, err.Error())
+	}
+	if err := openBackups(); err != nil {
+		log.Fatalf("Couldn't set up backups: %s\n", err.Error())
+	}
+	if config.BackupInterval > 0 {
+		go backupEvery(config.BackupInterval)
+	}
+	if err := tokens.load(); err != nil {
+		log.Fatalf("Couldn't load API tokens: %s\n", err.Error())
+	}
+	if err := blocks.load(); err != nil {
+		log.Fatalf("Couldn't load the blocklist: %s\n", err.Error())
+	}
+	if err := views.load(); err != nil {
+		log.Fatalf("Couldn't load view counts: %s\n", err.Error())
+	}
+	go views.flushEvery(viewFlushInterval)
+	if err := openAccessLog(); err != nil {
+		log.Fatalf("Couldn't open access log %s: %s\n", config.AccessLog, err.Error())
+	}
+	if err := setupTracing(); err != nil {
+		log.Fatalf("Couldn't set up tracing: %s\n", err.Error())
+	}
+	users.path = config.UsersFile
+	if err := users.load(); err != nil {
+		log.Fatalf("Couldn't load users from %s: %s\n", config.UsersFile, err.Error())
+	}
+	if err := openAuthenticator(); err != nil {
+		log.Fatalf("Couldn't set up %s logins: %s\n", config.Auth, err.Error())
+	}
+
 	mux := &http.ServeMux{}
 
-	mux.HandleFunc("/", indexHandler)
-	mux.HandleFunc("/view/", makeHandler(viewHandler))
-	mux.HandleFunc("/edit/", makeHandler(editHandler))
-	mux.HandleFunc("/save/", makeHandler(saveHandler))
+	mux.HandleFunc("/", homeHandler)
+	mux.HandleFunc("/index", indexHandler)
+	mux.Handle("/static/", staticHandler())
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	mux.HandleFunc("/theme.css", themeCSSHandler)
+	mux.HandleFunc("/manifest.webmanifest", manifestHandler)
+	mux.HandleFunc("/favicon.ico", faviconHandler)
+	mux.HandleFunc("/theme", themeHandler)
+	mux.HandleFunc("/language", languageHandler)
+	mux.HandleFunc("/view/", makeHandler(requirePermission(permRead, viewHandler)))
+	mux.HandleFunc("/edit/", requireWritable(requireEditor(makeHandler(requirePermission(permWrite, editHandler)))))
+	mux.HandleFunc("/save/", requireWritable(rateLimitWrites(requireEditor(makeHandler(requirePermission(permWrite, saveHandler))))))
+	mux.HandleFunc("/preview/", requireWritable(requireEditor(makeHandler(requirePermission(permWrite, previewHandler)))))
+	mux.HandleFunc("/new", requireWritable(requireAuth(newHandler)))
+	mux.HandleFunc("/live/", requireWritable(requireAuth(makeHandler(requirePermission(permWrite, livePreviewHandler)))))
+	mux.HandleFunc("/draft/", requireWritable(requireAuth(makeHandler(requirePermission(permWrite, draftHandler)))))
+	mux.HandleFunc("/watch/", requireAuth(makeHandler(requirePermission(permRead, watchHandler))))
+	mux.HandleFunc("/notifications", requireAuth(notificationsHandler))
+	mux.HandleFunc("/talk/", makeHandler(requirePermission(permRead, talkHandler)))
+	mux.HandleFunc("/comment/", requireWritable(rateLimitWrites(requireAuth(makeHandler(requirePermission(permWrite, commentHandler))))))
+	mux.HandleFunc("/raw/", makeHandler(requirePermission(permRead, rawHandler)))
+	mux.HandleFunc("/print/", makeHandler(requirePermission(permRead, printHandler)))
+	mux.HandleFunc("/history/", makeHandler(requirePermission(permRead, historyHandler)))
+	mux.HandleFunc("/backlinks/", makeHandler(requirePermission(permRead, backlinksHandler)))
+	mux.HandleFunc("/tag/", makeHandler(tagHandler))
+	mux.HandleFunc("/delete/", requireWritable(rateLimitWrites(requireAuth(makeHandler(requirePermission(permWrite, deleteHandler))))))
+	mux.HandleFunc("/upload/", requireWritable(rateLimitWrites(requireAuth(makeHandler(requirePermission(permWrite, uploadHandler))))))
+	mux.HandleFunc("/attachments/", attachmentHandler)
+	mux.HandleFunc("/diff/", diffHandler)
+	mux.HandleFunc("/restore/", requireWritable(rateLimitWrites(requireAuth(withTimeout(restoreHandler)))))
+	mux.HandleFunc("/search", withTimeout(searchHandler))
+	mux.HandleFunc("/changes", changesHandler)
+	mux.HandleFunc("/popular", popularHandler)
+	mux.HandleFunc("/reports", reportsHandler)
+	mux.HandleFunc("/reports/", reportsHandler)
+	mux.HandleFunc("/changes.atom", feedHandler)
+	mux.HandleFunc("/sitemap.xml", sitemapHandler)
+	mux.HandleFunc("/emoji.json", emojiHandler)
+	mux.HandleFunc("/titles.json", titlesHandler)
+	mux.HandleFunc("/help/shortcuts", shortcutsHandler)
+	mux.HandleFunc("/login", loginHandler)
+	mux.HandleFunc("/login/oidc/", oidcHandler)
+	mux.HandleFunc("/logout", logoutHandler)
+	mux.HandleFunc("/register", registerHandler)
+	mux.HandleFunc("/account", requireAuth(accountHandler))
+	mux.HandleFunc("/settings/tokens", requireAuth(tokensHandler))
+	mux.HandleFunc("/reset", resetHandler)
+	mux.HandleFunc("/reset/", resetHandler)
+	mux.HandleFunc("/admin/permissions/", permissionsHandler)
+	mux.HandleFunc("/trash", requireAdmin(trashHandler))
+	mux.HandleFunc("/trash/", requireWritable(requireAdmin(trashHandler)))
+	mux.HandleFunc("/export", requireAdmin(exportHandler))
+	mux.HandleFunc("/export/pdf/", withTimeout(pdfHandler))
+	mux.HandleFunc("/export/html/", withTimeout(standaloneHandler))
+	mux.HandleFunc("/import", requireWritable(rateLimitWrites(requireAdmin(importHandler))))
+	mux.HandleFunc("/admin", requireAdmin(adminHandler))
+	mux.HandleFunc("/admin/readonly", requireAdmin(readOnlyHandler))
+	mux.HandleFunc("/admin/audit", requireAdmin(auditHandler))
+	mux.HandleFunc("/admin/blocks", requireAdmin(blocksHandler))
+	mux.HandleFunc("/admin/status", requireAdmin(statusHandler))
+	mux.HandleFunc("/admin/stats", requireAdmin(withTimeout(statsHandler)))
+	mux.HandleFunc("/admin/webhooks", requireAdmin(webhooksHandler))
+	mux.HandleFunc("/admin/backups", requireAdmin(backupsHandler))
+	mux.HandleFunc("/admin/backups/", requireAdmin(backupsHandler))
+	mux.Handle("/debug/vars", requireAdmin(expvar.Handler().ServeHTTP))
+	mux.HandleFunc(apiPrefix, apiAuth(withTimeout(apiPagesHandler)))
+	mux.HandleFunc(apiPrefix+"/", apiAuth(withTimeout(apiPagesHandler)))
 
-	var handler http.Handler = mux
-	handler = logRequestHandler(handler)
 	srv := &http.Server{
 		ReadTimeout:  120 * time.Second,
 		WriteTimeout: 120 * time.Second,
 		IdleTimeout:  120 * time.Second,
-		Handler:      handler,
-		Addr:         ":8080",
+		Handler:      middleware(mux),
+		Addr:         config.Addr,
 	}
-	log.Fatal(srv.ListenAndServe())
+	return serve(srv)
+}
+
+// Wrap the routes in the middleware every request goes through, innermost
+// first. Recovery sits inside compression, so a panic's 500 page is what
+// gets compressed rather than the handler's half-finished response.
+func middleware(handler http.Handler) http.Handler {
+	handler = sessionHandler(handler)
+	handler = recoverHandler(handler)
+	handler = limitRequestBodies(handler)
+	handler = compressHandler(handler)
+	handler = stripBasePath(handler)
+	handler = logRequestHandler(handler)
+	handler = traceHandler(handler)
+	handler = requestIDHandler(handler)
+	return handler
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Reading speed used for the reading time, in words a minute
+const wordsPerMinute = 200
+
+// How many words the page has, leaving out its front matter and anything
+// that's only markup, such as table rules and list bullets
+func (p *Page) WordCount() int {
+	_, _, content := splitFrontMatter(p.Body)
+	words := 0
+	for _, field := range strings.Fields(string(content)) {
+		if strings.IndexFunc(field, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			words++
+		}
+	}
+	return words
+}
+
+// Roughly how many minutes the page takes to read, at least one if it has any words
+func (p *Page) ReadingMinutes() int {
+	words := p.WordCount()
+	if words == 0 {
+		return 0
+	}
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}